@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nilhiu/rei/rasm"
+)
+
+var (
+	boldColor  = color.New(color.Bold)
+	errorColor = color.New(color.FgRed, color.Bold)
+	noteColor  = color.New(color.FgCyan, color.Bold)
+	caretColor = color.New(color.FgGreen, color.Bold)
+)
+
+// setColorMode sets whether the output is colored. In the "auto" mode, it's
+// only colored if it's written to a terminal, and the NO_COLOR environment
+// variable isn't set.
+func setColorMode(mode string) error {
+	switch mode {
+	case "always":
+		color.NoColor = false
+	case "never":
+		color.NoColor = true
+	case "auto":
+		// The color package has already checked NO_COLOR and standard output.
+		color.NoColor = color.NoColor || !isTerminal(os.Stderr)
+	default:
+		return fmt.Errorf("unknown color mode \"%s\", expected auto, always or never", mode)
+	}
+
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// sources contains the lines of the source files by their names, which are
+// quoted by the diagnostics.
+type sources map[string][]string
+
+// lines returns the lines of the source file, which is read the first time
+// it's needed, like the files included by the input.
+func (s sources) lines(file string) []string {
+	if lines, ok := s[file]; ok {
+		return lines
+	}
+
+	var lines []string
+	if src, err := os.ReadFile(file); err == nil {
+		lines = strings.Split(string(src), "\n")
+	}
+
+	s[file] = lines
+
+	return lines
+}
+
+// printDiag prints the error, along with its notes, in the style of a
+// compiler's diagnostic, quoting the lines of the source it's located at.
+func printDiag(w io.Writer, srcs sources, err error) {
+	var srcErr *rasm.Error
+	if !errors.As(err, &srcErr) {
+		printErr(err.Error())
+		return
+	}
+
+	file := srcErr.File
+	printSnippet(w, srcs.lines(file), file, srcErr.Pos, srcErr.End, errorColor.Sprint("error:"), srcErr.Message())
+
+	for _, note := range srcErr.Notes {
+		// Notes can point to other files, like the invocation of a macro.
+		file := srcErr.File
+		if note.File != "" {
+			file = note.File
+		}
+
+		printSnippet(w, srcs.lines(file), file, note.Pos, note.End, noteColor.Sprint("note:"), note.Msg)
+	}
+}
+
+// printSnippet prints a message located at the given span of the source,
+// followed by the span's line, which is underlined with a caret at its start.
+func printSnippet(w io.Writer, src []string, file string, pos, end rasm.Position, label, msg string) {
+	loc := file
+	if pos.Line != 0 {
+		loc = fmt.Sprintf("%s:%d:%d", file, pos.Line, pos.Col+1)
+	}
+
+	fmt.Fprintf(w, "%s %s %s\n", boldColor.Sprint(loc+":"), label, msg)
+
+	if pos.Line == 0 || int(pos.Line) > len(src) {
+		return
+	}
+
+	line := []rune(strings.TrimSuffix(src[pos.Line-1], "\r"))
+	col := min(int(pos.Col), len(line))
+
+	width := 1
+	if end.Line == pos.Line && end.Col > pos.Col {
+		width = int(end.Col - pos.Col)
+	}
+
+	// Tabs are kept, so the caret lines up with the quoted line.
+	var indent strings.Builder
+	for _, r := range line[:col] {
+		if r == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+
+	num := strconv.FormatUint(uint64(pos.Line), 10)
+	gutter := strings.Repeat(" ", len(num)+1)
+
+	fmt.Fprintf(w, " %s | %s\n", num, string(line))
+	fmt.Fprintf(
+		w,
+		"%s | %s%s\n",
+		gutter,
+		indent.String(),
+		caretColor.Sprint("^"+strings.Repeat("~", width-1)),
+	)
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"debug/elf"
+	"encoding/binary"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/fatih/color"
@@ -40,8 +42,43 @@ func main() {
 				Value: false,
 				Usage: "tells rei to only output machine code (no object file)",
 			},
+			&cli.IntFlag{
+				Name:  "max-errors",
+				Value: 20,
+				Usage: "stops reporting errors after `N` errors (0 for no limit)",
+			},
+			&cli.StringSliceFlag{
+				Name:    "include",
+				Aliases: []string{"I"},
+				Usage:   "searches `DIR` for included files, after the including file's directory",
+			},
+			&cli.IntFlag{
+				Name:  "rep-limit",
+				Value: 1_000_000,
+				Usage: "stops %rep blocks, and the times prefix, from repeating more than `N` times",
+			},
+			&cli.StringSliceFlag{
+				Name:  "comments",
+				Usage: "also accepts line comments of `STYLE`, hash ('#') or slash ('//')",
+			},
+			&cli.StringFlag{
+				Name:  "color",
+				Value: "auto",
+				Usage: "colors the output `WHEN` is auto, always or never",
+			},
 		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
+			if err := setColorMode(cmd.String("color")); err != nil {
+				printErr(err.Error())
+				os.Exit(2)
+			}
+
+			comments, err := commentStyle(cmd.StringSlice("comments"))
+			if err != nil {
+				printErr(err.Error())
+				os.Exit(2)
+			}
+
 			var input string
 			if cmd.NArg() > 0 {
 				input = cmd.Args().Get(0)
@@ -62,11 +99,18 @@ func main() {
 				output = strings.TrimSuffix(input, filepath.Ext(input)) + ext
 			}
 
+			opts := options{
+				maxErrors: int(cmd.Int("max-errors")),
+				comments:  comments,
+				includes:  cmd.StringSlice("include"),
+				repLimit:  cmd.Int("rep-limit"),
+			}
+
 			var ok bool
 			if isBinOut {
-				ok = assembleBinary(input, output)
+				ok = assembleBinary(input, output, opts)
 			} else {
-				ok = assembleELF(input, output)
+				ok = assembleELF(input, output, opts)
 			}
 
 			if !ok {
@@ -84,45 +128,82 @@ func main() {
 	}
 }
 
-func assembleBinary(input string, output string) bool {
-	fin, err := os.Open(input)
-	if err != nil {
-		panic(err)
+// options contains the options of the assembly.
+type options struct {
+	// maxErrors is the maximum number of errors reported, unless it's zero.
+	maxErrors int
+	// comments contains the styles of comments accepted besides the ones
+	// starting with ';'.
+	comments rasm.CommentStyle
+	includes []string // the directories searched for included files
+	repLimit int64    // the maximum count of a %rep block's iterations, or times copies
+}
+
+// assembleBinary assembles the input into a flat binary, in which the sections
+// are placed one after another, in the order they first appear. Sections
+// without data are placed after the binary's end.
+func assembleBinary(input string, output string, opts options) bool {
+	cg, sectCode, ok := assemble(input, opts)
+	if !ok {
+		return false
 	}
-	defer fin.Close()
 
-	fout, err := os.Create(output)
-	if err != nil {
-		panic(err)
+	base := map[string]uint64{}
+	code := []byte{}
+	for _, sect := range cg.Sections() {
+		if !sect.NoBits {
+			base[sect.Name] = uint64(len(code))
+			code = append(code, sectCode[sect.Name].Bytes()...)
+		}
 	}
-	defer fout.Close()
 
-	cg := rasm.NewCodeGen(fin)
-	for {
-		bs, _, err := cg.Next()
-		if err != nil {
-			printErr(err.Error())
+	end := uint64(len(code))
+	for _, sect := range cg.Sections() {
+		if sect.NoBits {
+			base[sect.Name] = end
+			end += sect.Size
+		}
+	}
+
+	for _, fixup := range cg.Fixups() {
+		li := cg.Labels()[fixup.Label]
+		if fixup.Kind == rasm.FixupGOTRel {
+			printErr("GOT entry of label \"" + fixup.Label + "\" cannot be referred to in a binary")
+			return false
+		} else if fixup.Label == "" {
+			// The field refers to the start of its own section.
+			li.Section = fixup.Section
+		} else if li.Binding == rasm.ExternBinding {
+			printErr("extern label \"" + fixup.Label + "\" cannot be referred to in a binary")
 			return false
 		}
 
-		if bs == nil {
-			return true
+		val := base[li.Section] + li.Offset + uint64(fixup.Addend)
+		if fixup.Kind == rasm.FixupRel || fixup.Kind == rasm.FixupBranch {
+			val -= base[fixup.Section] + fixup.Offset
 		}
 
-		_, err = fout.Write(bs)
-		if err != nil {
-			panic(err)
+		field := code[base[fixup.Section]+fixup.Offset:]
+		if fixup.Size == 8 {
+			binary.LittleEndian.PutUint64(field, val)
+		} else {
+			binary.LittleEndian.PutUint32(field, uint32(val))
 		}
 	}
+
+	if err := os.WriteFile(output, code, 0o644); err != nil {
+		panic(err)
+	}
+
+	return true
 }
 
 // TODO: Find a clearer way to do this...
-func assembleELF(input string, output string) bool {
-	fin, err := os.Open(input)
-	if err != nil {
-		panic(err)
+func assembleELF(input string, output string, opts options) bool {
+	cg, sectCode, ok := assemble(input, opts)
+	if !ok {
+		return false
 	}
-	defer fin.Close()
 
 	fout, err := os.Create(output)
 	if err != nil {
@@ -130,75 +211,236 @@ func assembleELF(input string, output string) bool {
 	}
 	defer fout.Close()
 
-	sectCode := map[string]*bytes.Buffer{}
 	sectIndex := map[string]uint16{}
 
-	cg := rasm.NewCodeGen(fin)
-	for {
-		bs, sect, err := cg.Next()
-		if err != nil {
-			printErr(err.Error())
-			return false
-		}
-
-		if bs == nil {
-			break
-		}
-
-		if buf, ok := sectCode[sect]; !ok {
-			sectCode[sect] = bytes.NewBuffer(bs)
-		} else {
-			buf.Write(bs)
-		}
-	}
-
 	w := relf.New(input, relf.Header64{
 		Endian:  elf.ELFDATA2LSB,
 		ABI:     elf.ELFOSABI_NONE,
 		Machine: elf.EM_X86_64,
 	}, fout)
 
-	i := 1
-	for k, buf := range sectCode {
-		fmt.Println("section", k, "size", buf.Len())
+	for i, sect := range cg.Sections() {
+		typ := elf.SHT_PROGBITS
+		if sect.NoBits {
+			typ = elf.SHT_NOBITS
+		}
+
 		err := w.WriteSection(relf.Section64{
-			Name:      k,
-			Type:      elf.SHT_PROGBITS,
+			Name:      sect.Name,
+			Type:      typ,
 			Addralign: 16,
 			Entsize:   0,
-			Flags:     elf.SHF_EXECINSTR | elf.SHF_ALLOC,
-			Code:      buf.Bytes(),
+			Flags:     sectionFlags(sect.Name),
+			Code:      sectCode[sect.Name].Bytes(),
+			Size:      sect.Size,
 		})
 		if err != nil {
 			return false
 		}
 
-		sectIndex[k] = uint16(i)
-		i++
+		sectIndex[sect.Name] = uint16(i + 1)
 	}
 
-	for k, li := range cg.Labels() {
-		err := w.WriteSymbol(relf.Symbol64{
+	labels := cg.Labels()
+	for _, k := range sortedKeys(labels) {
+		li := labels[k]
+		symb := relf.Symbol64{
 			Name:  k,
 			Type:  elf.STT_NOTYPE,
-			Bind:  elf.STB_GLOBAL,
+			Bind:  elf.STB_LOCAL,
 			Shndx: sectIndex[li.Section],
 			Value: li.Offset,
+		}
+
+		switch li.Binding {
+		case rasm.GlobalBinding:
+			symb.Bind = elf.STB_GLOBAL
+		case rasm.ExternBinding:
+			symb.Bind = elf.STB_GLOBAL
+			symb.Shndx = uint16(elf.SHN_UNDEF)
+		}
+
+		if err := w.WriteSymbol(symb); err != nil {
+			return false
+		}
+	}
+
+	consts := cg.Constants()
+	for _, k := range sortedKeys(consts) {
+		if consts[k].Binding != rasm.GlobalBinding {
+			continue
+		}
+
+		err := w.WriteSymbol(relf.Symbol64{
+			Name:  k,
+			Type:  elf.STT_NOTYPE,
+			Bind:  elf.STB_GLOBAL,
+			Shndx: uint16(elf.SHN_ABS),
+			Value: uint64(consts[k].Value),
 		})
 		if err != nil {
 			return false
 		}
 	}
 
+	for _, fixup := range cg.Fixups() {
+		rel := relf.Relocation64{
+			Offset: fixup.Offset,
+			Symbol: fixup.Label,
+			Type:   uint32(relocType(fixup)),
+			Addend: fixup.Addend,
+		}
+
+		// Local labels are relocated against their section instead, just like
+		// the fields referring to the start of their own section. References
+		// to a GOT entry keep their label, since the entry belongs to it.
+		if fixup.Label == "" {
+			rel.Symbol, rel.Section = "", fixup.Section
+		} else if li := labels[fixup.Label]; li.Binding == rasm.LocalBinding && fixup.Kind != rasm.FixupGOTRel {
+			rel.Symbol, rel.Section = "", li.Section
+			rel.Addend += int64(li.Offset)
+		}
+
+		if err := w.WriteRelocation(fixup.Section, rel); err != nil {
+			return false
+		}
+	}
+
 	if err := w.Flush(); err != nil {
+		printErr(err.Error())
 		return false
 	}
 
 	return true
 }
 
+// assemble assembles the input, returning the code of each section. If the
+// input has errors, at most opts.maxErrors of them are reported.
+func assemble(input string, opts options) (*rasm.CodeGen, map[string]*bytes.Buffer, bool) {
+	src, err := os.ReadFile(input)
+	if err != nil {
+		printErr(err.Error())
+		return nil, nil, false
+	}
+
+	srcs := sources{input: strings.Split(string(src), "\n")}
+	sectCode := map[string]*bytes.Buffer{}
+
+	lxr := rasm.NewLexerFile(input, bytes.NewReader(src))
+	lxr.SetCommentStyle(opts.comments)
+
+	pp := rasm.NewPreprocessor(lxr)
+	pp.SetIncludePaths(opts.includes)
+	pp.SetRepLimit(opts.repLimit)
+
+	cg := rasm.NewCodeGenParser(rasm.NewParserPreprocessor(pp))
+	if errs := cg.Errors(); len(errs) != 0 {
+		for i, err := range errs {
+			if opts.maxErrors > 0 && i == opts.maxErrors {
+				printErr(fmt.Sprintf("too many errors, %d more omitted", len(errs)-i))
+				break
+			}
+
+			printDiag(os.Stderr, srcs, err)
+		}
+
+		return nil, nil, false
+	}
+
+	for {
+		bs, sect, err := cg.Next()
+		if err != nil {
+			printDiag(os.Stderr, srcs, err)
+			return nil, nil, false
+		}
+
+		if bs == nil {
+			// Sections with only labels, or reservations, have no code.
+			for _, sect := range cg.Sections() {
+				if _, ok := sectCode[sect.Name]; !ok {
+					sectCode[sect.Name] = &bytes.Buffer{}
+				}
+			}
+
+			return cg, sectCode, true
+		}
+
+		if buf, ok := sectCode[sect]; !ok {
+			sectCode[sect] = bytes.NewBuffer(bs)
+		} else {
+			buf.Write(bs)
+		}
+	}
+}
+
+// commentStyle returns the styles of comments with the given names.
+func commentStyle(names []string) (rasm.CommentStyle, error) {
+	var style rasm.CommentStyle
+	for _, name := range names {
+		switch name {
+		case "hash":
+			style |= rasm.HashComments
+		case "slash":
+			style |= rasm.SlashComments
+		default:
+			return 0, fmt.Errorf("unknown comment style \"%s\", expected hash or slash", name)
+		}
+	}
+
+	return style, nil
+}
+
+// sectionFlags returns the ELF flags of a section, based on its name.
+func sectionFlags(name string) elf.SectionFlag {
+	hasPrefix := func(prefix string) bool {
+		return name == prefix || strings.HasPrefix(name, prefix+".")
+	}
+
+	switch {
+	case hasPrefix(".text"):
+		return elf.SHF_EXECINSTR | elf.SHF_ALLOC
+	case hasPrefix(".data"), hasPrefix(".bss"):
+		return elf.SHF_WRITE | elf.SHF_ALLOC
+	case hasPrefix(".tdata"), hasPrefix(".tbss"):
+		return elf.SHF_WRITE | elf.SHF_ALLOC | elf.SHF_TLS
+	}
+
+	return elf.SHF_ALLOC
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+// relocType returns the x86-64 relocation type of the fixup.
+func relocType(fixup rasm.Fixup) elf.R_X86_64 {
+	switch fixup.Kind {
+	case rasm.FixupAbsSigned:
+		return elf.R_X86_64_32S
+	case rasm.FixupRel:
+		return elf.R_X86_64_PC32
+	case rasm.FixupBranch:
+		return elf.R_X86_64_PLT32
+	case rasm.FixupGOTRel:
+		return elf.R_X86_64_GOTPCREL
+	}
+
+	if fixup.Size == 8 {
+		return elf.R_X86_64_64
+	}
+
+	return elf.R_X86_64_32
+}
+
 func printErr(msg string) {
-	fmt.Fprintln(os.Stderr, color.New(color.FgRed, color.Bold).Sprint("[err]:"), msg)
+	fmt.Fprintln(os.Stderr, boldColor.Sprint("rei:"), errorColor.Sprint("error:"), msg)
 }
 
 func printInfo(msg string) {
@@ -1,26 +1,130 @@
 package rasm
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
-	"strconv"
+	"slices"
+	"strings"
 
 	"github.com/nilhiu/rei/x86"
 )
 
+// relocPlaceholder is used in place of a label's address while encoding an
+// instruction. It's large enough to keep the field from being compressed
+// into a single byte, and is cleared after encoding.
+const relocPlaceholder = 0x80
+
+// maxLayouts is the maximum number of times the code is laid out, before
+// giving up on the label offsets settling.
+const maxLayouts = 64
+
 // A CodeGen represents an object that turns the expressions parsed by the
 // [Parser], to machine code.
+//
+// As labels can be referred to before they are defined, the whole source is
+// parsed on the first call to [CodeGen.Next], after which the code is laid out
+// until the offsets of all labels are known.
 type CodeGen struct {
 	p       *Parser
 	section string
+	// scope is the last non-local label, to which local labels, starting with
+	// '.', belong.
+	scope   string
 	sectPos map[string]uint64
 	labels  map[string]LabelInfo
+	consts  map[string]*constant
+	fixups  []Fixup
+	// placed contains the labels which were laid out at least once.
+	placed map[string]bool
+	// defs contains the tokens which defined the labels and constants, to
+	// point to in the errors of their redefinitions.
+	defs map[string]Token
+	// here is the item being laid out, whose position '$' evaluates to.
+	here *item
+	// files contains the contents of the files included by incbin directives,
+	// by their paths, so they're only read once.
+	files map[string][]byte
+
+	items     []item
+	itemIx    int
+	assembled bool
 }
 
 // A LabelInfo represents information about a label.
 type LabelInfo struct {
-	Section string // the section the label is located in
-	Offset  uint64 // the offset from the section the label's at
+	Section string  // the section the label is located in
+	Offset  uint64  // the offset from the section the label's at
+	Binding Binding // the visibility of the label outside of the source
+}
+
+// A SectionInfo represents information about a section.
+type SectionInfo struct {
+	Name   string // the name of the section
+	Size   uint64 // the size of the section, including the reserved space
+	NoBits bool   // reports if the section only reserves space, without any data
+}
+
+// A Binding represents the visibility of a label outside of the source it's
+// defined in.
+type Binding uint
+
+const (
+	LocalBinding  Binding = iota // the label is only visible in its source, the default
+	GlobalBinding                // the label is visible to other object files
+	ExternBinding                // the label is defined in another object file
+)
+
+// A FixupKind represents how a label's address is encoded in a [Fixup].
+type FixupKind uint
+
+const (
+	FixupAbs       FixupKind = iota // the field is the label's address
+	FixupAbsSigned                  // the field is sign-extended to the label's address
+	FixupRel                        // the field is relative to its own address
+	FixupBranch                     // the field is a branch's target, relative to its own address
+	FixupGOTRel                     // the field is the label's GOT entry, relative to its own address
+)
+
+// A Fixup represents a field in the generated code which refers to a label,
+// and has to be patched, or relocated, once the label's address is known.
+// The field's value is the label's address plus the addend, from which the
+// field's own address is subtracted for [FixupRel] fixups, while [FixupGOTRel]
+// fields use the address of the label's entry in the global offset table.
+// Fields without a label refer to the start of their own section instead, like
+// the ones referring to '$'.
+type Fixup struct {
+	Kind    FixupKind
+	Section string // the section the field is located in
+	Offset  uint64 // the offset of the field from the section's start
+	Size    uint   // the size of the field, in bytes
+	Label   string // the label the field refers to, if any
+	Addend  int64  // the constant added to the label's address
+}
+
+// An item represents a parsed expression, which is laid out in a section.
+type item struct {
+	expr    Expr
+	section string
+	offset  uint64
+	code    []byte
+	err     error
+	near    bool   // reports if a branch has to use its near encoding
+	scope   string // the scope of the local labels referred to by the item
+	// reserved is the space reserved by the item in a section without data,
+	// which follows its code.
+	reserved uint64
+}
+
+// A labelRef represents an operand's reference to a label.
+type labelRef struct {
+	label  string
+	addend int64
+	mem    bool // reports if the label is referred to by an address
+	rel    bool // reports if the field is relative to the end of the instruction
+	branch bool // reports if the field is a branch's target
+	got    bool // reports if the field refers to the label's GOT entry
 }
 
 // NewCodeGen creates a new code generator based on the [io.Reader] given to it.
@@ -36,94 +140,713 @@ func NewCodeGenParser(p *Parser) *CodeGen {
 		section: ".text",
 		sectPos: map[string]uint64{},
 		labels:  map[string]LabelInfo{},
+		consts:  map[string]*constant{},
+		placed:  map[string]bool{},
+		defs:    map[string]Token{},
+		files:   map[string][]byte{},
 	}
 }
 
-// Next generates machine code for the next [InstrExpr] expression. It returns
-// the machine code itself, the section it's in, and possibly an error. If the
-// file has been fully read, Next will always return a nil slice with no error.
+// Next returns the machine code generated for the next [InstrExpr], or data
+// [DirectiveExpr], expression. It returns the machine code itself, the section it's in, and
+// possibly an error. If the file has been fully read, Next will always return
+// a nil slice with no error.
 func (cg *CodeGen) Next() ([]byte, string, error) {
+	if !cg.assembled {
+		cg.assemble()
+	}
+
+	for cg.itemIx < len(cg.items) {
+		it := cg.items[cg.itemIx]
+		cg.itemIx++
+
+		if it.code != nil || it.err != nil {
+			return it.code, it.section, it.err
+		}
+	}
+
+	return nil, cg.section, nil
+}
+
+// Labels returns a map of names to label information of the encountered
+// labels by the [CodeGen].
+func (cg *CodeGen) Labels() map[string]LabelInfo {
+	return cg.labels
+}
+
+// Sections returns information about the sections used by the code, in the
+// order they first appear.
+func (cg *CodeGen) Sections() []SectionInfo {
+	sects := []SectionInfo{}
+	seen := map[string]bool{}
+
+	for _, it := range cg.items {
+		if seen[it.section] {
+			continue
+		}
+		seen[it.section] = true
+
+		sects = append(sects, SectionInfo{
+			Name:   it.section,
+			Size:   cg.sectPos[it.section],
+			NoBits: IsNoBits(it.section),
+		})
+	}
+
+	return sects
+}
+
+// Errors returns all the errors found in the code, in the order they're
+// returned by [CodeGen.Next].
+func (cg *CodeGen) Errors() []error {
+	if !cg.assembled {
+		cg.assemble()
+	}
+
+	errs := []error{}
+	for _, it := range cg.items {
+		if it.err != nil {
+			errs = append(errs, it.err)
+		}
+	}
+
+	return errs
+}
+
+// Fixups returns the fields of the generated code which refer to labels,
+// and have to be patched, or relocated.
+func (cg *CodeGen) Fixups() []Fixup {
+	return cg.fixups
+}
+
+func (cg *CodeGen) assemble() {
+	cg.assembled = true
+
 	for {
 		expr := cg.p.Next()
 
+		var err error
 		switch expr.ID {
 		case LabelExpr:
-			ok := cg.addLabel(expr.Root.Raw())
-			if !ok {
-				return nil, cg.section, errors.New("label already exists")
+			if name := expr.Root.Raw(); !isLocal(name) && !strings.HasPrefix(name, macroLabelPrefix) {
+				cg.scope = name
 			}
 
-			continue
+			err = cg.addLabel(expr.Root)
 		case InstrExpr:
-			bytes, err := cg.genInstruction(expr)
-			cg.addCurrentSectOff(uint64(len(bytes)))
-
-			return bytes, cg.section, err
+		case DirectiveExpr:
+			err = cg.addDirective(expr)
 		case SectionExpr:
 			cg.section = expr.Children[0].Raw()
 
 			continue
 		case EOFExpr:
-			return nil, cg.section, nil
+			cg.bindLabels()
+			cg.layoutAll()
+			cg.checkConstExports()
+			cg.locateErrors()
+
+			return
+		case IllegalExpr:
+			err = expr.Err
+		default:
+			err = newError(SyntaxError, "codegen expression not supported")
 		}
 
-		return nil, cg.section, errors.New("codegen expression not supported")
+		cg.items = append(cg.items, item{expr: expr, section: cg.section, err: err, scope: cg.scope})
 	}
 }
 
-// Labels returns a map of names to label information of the encountered
-// labels by the [CodeGen].
-func (cg *CodeGen) Labels() map[string]LabelInfo {
-	return cg.labels
+// layoutAll lays out the items until the offsets of all labels settle. As
+// branches only ever grow from their short to near encodings, this is bound
+// to happen.
+func (cg *CodeGen) layoutAll() {
+	// The first layout can't know the offsets of labels which are referred to
+	// before they're defined, so it's always repeated.
+	cg.layout()
+
+	for i := 0; cg.layout(); i++ {
+		if i == maxLayouts {
+			cg.items = append(cg.items, item{
+				section: cg.section,
+				err:     newError(LayoutError, "label offsets failed to settle"),
+			})
+
+			return
+		}
+	}
 }
 
-func (cg *CodeGen) addLabel(label string) bool {
-	_, ok := cg.labels[label]
-	if ok {
-		return false
+// layout lays out the items in their sections and generates their code, using
+// the label offsets known so far. It reports if any label's offset changed.
+func (cg *CodeGen) layout() bool {
+	changed := false
+	cg.sectPos = map[string]uint64{}
+	cg.fixups = []Fixup{}
+
+	for _, c := range cg.consts {
+		c.set = false
 	}
 
-	cg.labels[label] = LabelInfo{
-		Section: cg.section,
-		Offset:  cg.getCurrentSectOff(),
+	for i := range cg.items {
+		it := &cg.items[i]
+		it.offset = cg.sectPos[it.section]
+		cg.scope, cg.here = it.scope, it
+
+		switch it.expr.ID {
+		case LabelExpr:
+			if it.err != nil {
+				continue
+			}
+
+			label := cg.qualify(it.expr.Root.Raw())
+			info := cg.labels[label]
+			if info.Offset != it.offset {
+				changed = true
+			}
+
+			info.Section, info.Offset = it.section, it.offset
+			cg.labels[label] = info
+			cg.placed[label] = true
+		case InstrExpr:
+			it.code, it.err = cg.repeat(it, cg.genInstruction)
+		case DirectiveExpr:
+			dir := DirectiveID(it.expr.Root.SpecID())
+
+			// Data is rejected even if it has no bytes, like an empty file.
+			if _, isData := dataSizes[dir]; (isData || dir == IncbinDir) && IsNoBits(it.section) {
+				it.code, it.err = nil, newError(InvalidDirective, "section without data can only reserve space")
+				break
+			}
+
+			if size, ok := dataSizes[dir]; ok {
+				it.code, it.err = cg.repeat(it, func(it *item) ([]byte, error) {
+					return cg.genData(it, size)
+				})
+			} else if size, ok := reserveSizes[dir]; ok {
+				it.reserved = 0
+				it.code, it.err = cg.repeat(it, func(it *item) ([]byte, error) {
+					return cg.genReserve(it, size)
+				})
+			} else if dir == IncbinDir {
+				it.code, it.err = cg.genIncbin(it)
+			} else if dir == AssignDir && it.err == nil {
+				it.err = cg.assign(it)
+			}
+		}
+
+		if len(it.code) != 0 && IsNoBits(it.section) {
+			it.code, it.err = nil, newError(InvalidDirective, "section without data can only reserve space")
+		}
+
+		size, limit := uint64(len(it.code))+it.reserved, maxSectionSize(it.section)
+		if size > limit-cg.sectPos[it.section] {
+			it.code, it.reserved, size = nil, 0, 0
+			it.err = newError(OutOfRange, fmt.Sprintf("section exceeds the maximum size of %d bytes", limit))
+		}
+
+		cg.sectPos[it.section] += size
+	}
+
+	return changed
+}
+
+// locateErrors locates the errors of the items at their expressions, so even
+// the errors returned by the x86 package point to the code causing them.
+func (cg *CodeGen) locateErrors() {
+	for i := range cg.items {
+		it := &cg.items[i]
+		if it.err == nil {
+			continue
+		}
+
+		pos, end := exprSpan(it.expr)
+		it.err = locate(it.err, it.expr.Root.File(), pos, end)
+
+		var srcErr *Error
+		if !errors.As(it.err, &srcErr) {
+			continue
+		}
+
+		if srcErr.File == "" {
+			srcErr.File = cg.p.file
+		}
+
+		if exp := exprExpansion(it.expr); exp != nil {
+			srcErr.Notes = append(srcErr.Notes, exp.notes()...)
+		}
+	}
+}
+
+// repeat generates the code of an item, which is repeated by its times prefix.
+// Each copy is generated at its own offset, as the code may depend on it, like
+// the code of a branch. The count of the copies is limited like the iterations
+// of a %rep block.
+func (cg *CodeGen) repeat(it *item, gen func(*item) ([]byte, error)) ([]byte, error) {
+	if it.expr.Times == nil {
+		return gen(it)
 	}
 
-	return true
+	limit := cg.p.pp.repLimit
+
+	val, err := cg.evalNode(it.expr.Times)
+	switch {
+	case err != nil:
+	case !val.isConst():
+		err = newError(NotConstant, "times count must be a constant")
+	case val.n < 0:
+		err = newError(OutOfRange, fmt.Sprintf("times count cannot be negative, but it's %d", val.n))
+	case val.n > limit:
+		err = newError(OutOfRange, fmt.Sprintf("times count %d exceeds the limit of %d repetitions", val.n, limit))
+	}
+
+	if err != nil {
+		pos, end := nodeSpan(it.expr.Times)
+		return nil, locate(err, it.expr.Times.Tok.File(), pos, end)
+	}
+
+	start := it.offset
+	defer func() { it.offset = start }()
+
+	var code []byte
+	reserved := uint64(0)
+
+	for i := int64(0); i < val.n; i++ {
+		it.offset = start + uint64(len(code)) + reserved
+		it.reserved = 0
+
+		copyCode, err := gen(it)
+		if err != nil {
+			it.reserved = 0
+			return nil, err
+		}
+
+		// Copies which only reserve space, in a section without data, are all
+		// the same, so their space is added up at once.
+		if len(copyCode) == 0 {
+			return nil, reserveCopies(it, uint64(val.n))
+		}
+
+		code = append(code, copyCode...)
+		reserved += it.reserved
+	}
+
+	it.reserved = reserved
+
+	return code, nil
+}
+
+// reserveCopies reserves the space of all the copies of an item, which only
+// reserves space, given the space reserved by its first copy.
+func reserveCopies(it *item, copies uint64) error {
+	limit := maxSectionSize(it.section)
+	if it.reserved != 0 && copies > (limit-it.offset)/it.reserved {
+		it.reserved = 0
+
+		err := newError(OutOfRange, fmt.Sprintf("reserved space exceeds the maximum section size of %d bytes", limit))
+		pos, end := nodeSpan(it.expr.Times)
+
+		return locate(err, it.expr.Times.Tok.File(), pos, end)
+	}
+
+	it.reserved *= copies
+
+	return nil
 }
 
-func (cg *CodeGen) genInstruction(expr Expr) ([]byte, error) {
+// isLocal reports if the label is local to the last non-local label. The
+// labels local to a macro's expansion aren't, as their names are unique.
+func isLocal(label string) bool {
+	return strings.HasPrefix(label, ".") && !strings.HasPrefix(label, macroLabelPrefix)
+}
+
+// qualify returns the full name of a label, which for local labels is prefixed
+// by the name of the non-local label they belong to, such as "parent.loop".
+func (cg *CodeGen) qualify(label string) string {
+	if isLocal(label) {
+		return cg.scope + label
+	}
+
+	return label
+}
+
+func (cg *CodeGen) addLabel(tok Token) error {
+	label := cg.qualify(tok.Raw())
+
+	_, isLabel := cg.labels[label]
+	if _, isConst := cg.consts[label]; isLabel || isConst {
+		return cg.redefinition(label, "label already exists")
+	}
+
+	cg.labels[label] = LabelInfo{Section: cg.section}
+	cg.defs[label] = tok
+
+	return nil
+}
+
+// redefinition returns the error of a redefined name, with a note pointing to
+// its previous definition.
+func (cg *CodeGen) redefinition(name string, msg string) *Error {
+	err := newError(DuplicateLabel, msg)
+
+	if tok, ok := cg.defs[name]; ok {
+		what := "label"
+		if _, ok := cg.consts[name]; ok {
+			what = "constant"
+		}
+
+		err.Notes = []Note{{
+			File: tok.File(),
+			Pos:  tok.Pos(),
+			End:  tokenEnd(tok),
+			Msg:  "previous definition of " + what + " here",
+		}}
+	}
+
+	return err
+}
+
+// addDirective handles a directive expression when it's first encountered.
+// Extern labels are added right away, so they can be referred to like any other
+// label, while the rest of the binding directives are handled by
+// [CodeGen.bindLabels], once all labels are known.
+func (cg *CodeGen) addDirective(expr Expr) error {
+	switch DirectiveID(expr.Root.SpecID()) {
+	case EquDir, AssignDir:
+		return cg.addConst(expr)
+	case GlobalDir, StaticDir:
+		_, err := directiveLabels(expr)
+		return err
+	case ExternDir:
+	default:
+		return nil
+	}
+
+	labels, err := directiveLabels(expr)
+	if err != nil {
+		return err
+	}
+
+	for _, tok := range labels {
+		label := cg.qualify(tok.Raw())
+		if info, ok := cg.labels[label]; ok && info.Binding != ExternBinding {
+			return cg.redefinition(label, "label already exists")
+		} else if _, ok := cg.consts[label]; ok {
+			return cg.redefinition(label, "name is already defined")
+		}
+
+		if _, ok := cg.defs[label]; !ok {
+			cg.defs[label] = tok
+		}
+
+		cg.labels[label] = LabelInfo{Binding: ExternBinding}
+	}
+
+	return nil
+}
+
+// bindLabels sets the bindings of the labels declared by the global and
+// static directives.
+func (cg *CodeGen) bindLabels() {
+	declared := map[string]Binding{}
+
+	for i := range cg.items {
+		it := &cg.items[i]
+		if it.expr.ID != DirectiveExpr || it.err != nil {
+			continue
+		}
+
+		cg.scope = it.scope
+
+		binding := LocalBinding
+		switch DirectiveID(it.expr.Root.SpecID()) {
+		case GlobalDir:
+			binding = GlobalBinding
+		case StaticDir:
+		default:
+			continue
+		}
+
+		labels, _ := directiveLabels(it.expr)
+		for _, tok := range labels {
+			label := cg.qualify(tok.Raw())
+			if c, ok := cg.consts[label]; ok {
+				if prev, ok := declared[label]; ok && prev != binding {
+					it.err = newError(InvalidDirective, "label is already declared with another binding")
+					break
+				}
+
+				declared[label] = binding
+				c.binding = binding
+
+				continue
+			}
+
+			info, ok := cg.labels[label]
+			if !ok {
+				it.err = newError(UndefinedLabel, "declared label is never defined")
+				break
+			}
+
+			if prev, ok := declared[label]; info.Binding == ExternBinding || (ok && prev != binding) {
+				it.err = newError(InvalidDirective, "label is already declared with another binding")
+				break
+			}
+
+			declared[label] = binding
+			info.Binding = binding
+			cg.labels[label] = info
+		}
+	}
+}
+
+// directiveLabels returns the tokens of the labels given as operands to a
+// binding directive.
+func directiveLabels(expr Expr) ([]Token, error) {
+	if len(expr.Operands) == 0 {
+		return nil, newError(InvalidDirective, "directive expects at least one label")
+	}
+
+	labels := []Token{}
+	for _, op := range expr.Operands {
+		if op.ID != ImmOperand || op.Expr.Left != nil || op.Expr.Tok.ID() != Identifier {
+			return nil, newError(InvalidDirective, "directive expects label names as operands")
+		}
+
+		labels = append(labels, op.Expr.Tok)
+	}
+
+	return labels, nil
+}
+
+func (cg *CodeGen) genInstruction(it *item) ([]byte, error) {
+	mnem := x86.Mnemonic(it.expr.Root.SpecID())
+	if mnem.IsBranch() && len(it.expr.Operands) == 1 && it.expr.Operands[0].ID == ImmOperand {
+		return cg.genBranch(it, mnem)
+	}
+
 	ops := []x86.Operand{}
+	var ref *labelRef
 
-	for _, t := range expr.Children {
-		op, err := toOperand(t)
+	for _, operand := range it.expr.Operands {
+		op, opRef, err := cg.toOperand(it, operand)
 		if err != nil {
 			return nil, err
 		}
 
+		if opRef != nil {
+			if ref != nil {
+				return nil, newError(InvalidOperand, "instruction can only refer to a single label")
+			}
+
+			ref = opRef
+		}
+
 		ops = append(ops, op)
 	}
 
-	return x86.Translate(x86.Mnemonic(expr.Root.SpecID()), ops...)
+	inst, err := x86.Encode(mnem, ops...)
+	if errors.Is(err, x86.ErrOutOfRange) {
+		// Point to the immediate, which doesn't fit in its field.
+		ix := slices.IndexFunc(it.expr.Operands, func(op Operand) bool { return op.ID == ImmOperand })
+		if ix != -1 {
+			pos, end := nodeSpan(it.expr.Operands[ix].Expr)
+			err = locate(err, it.expr.Root.File(), pos, end)
+		}
+	}
+
+	if err != nil || ref == nil {
+		return inst.Bytes, err
+	}
+
+	// Immediates narrower than their destination are sign-extended.
+	var size uint
+	switch dest := ops[0].(type) {
+	case x86.Register:
+		size = dest.Size()
+	case x86.Address:
+		size = dest.Size
+	}
+
+	signed := uint(inst.ImmSize*8) < size
+
+	return inst.Bytes, cg.resolveRef(it, inst, *ref, signed)
+}
+
+// genBranch generates code for a branch to a label. Branches within the same
+// section use their short encoding, until their target is out of its reach.
+func (cg *CodeGen) genBranch(it *item, mnem x86.Mnemonic) ([]byte, error) {
+	val, err := cg.evalNode(it.expr.Operands[0].Expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !val.isRelocatable() || len(val.regs) != 0 {
+		return nil, newError(InvalidOperand, "branch target must be a label")
+	}
+
+	name, err := refLabel(it, val)
+	if err != nil {
+		return nil, err
+	}
+
+	label, placed := cg.labels[name], cg.placed[name]
+	if name == "" {
+		label, placed = LabelInfo{Section: it.section}, true
+	}
+
+	if label.Section != it.section {
+		inst, err := x86.Encode(mnem, x86.Relative{Near: true})
+		if err != nil {
+			return nil, err
+		}
+
+		ref := labelRef{label: name, addend: val.n, rel: true, branch: true}
+
+		return inst.Bytes, cg.resolveRef(it, inst, ref, false)
+	}
+
+	// Labels which weren't laid out yet are assumed to be in reach.
+	rel := x86.Relative{Near: it.near}
+	if placed {
+		rel.Offset = int64(label.Offset) + val.n - int64(it.offset)
+	}
+
+	inst, err := x86.Encode(mnem, rel)
+	if inst.ImmSize == 4 {
+		it.near = true
+	}
+
+	return inst.Bytes, err
 }
 
-func (cg *CodeGen) addCurrentSectOff(off uint64) {
-	cg.sectPos[cg.section] += off
+// resolveRef creates a fixup for the field of the encoded instruction which
+// refers to a label. RIP-relative references to labels in the same section
+// are resolved in place instead, unless they refer to the label's GOT entry.
+func (cg *CodeGen) resolveRef(it *item, inst x86.Instruction, ref labelRef, signed bool) error {
+	off, size := inst.ImmOffset(), inst.ImmSize
+	if ref.mem {
+		off, size = inst.DispOffset(), inst.DispSize
+	}
+
+	field := inst.Bytes[off : off+size]
+	clear(field)
+
+	fixup := Fixup{
+		Kind:    FixupAbs,
+		Section: it.section,
+		Offset:  it.offset + uint64(off),
+		Size:    uint(size),
+		Label:   ref.label,
+		Addend:  ref.addend,
+	}
+
+	switch {
+	case ref.rel:
+		// The field is relative to the end of the instruction.
+		fixup.Kind = FixupRel
+		fixup.Addend -= int64(len(inst.Bytes) - off)
+
+		switch {
+		case ref.got:
+			fixup.Kind = FixupGOTRel
+		case ref.branch:
+			fixup.Kind = FixupBranch
+		}
+
+		label := cg.labels[ref.label]
+		if ref.label == "" {
+			label = LabelInfo{Section: it.section}
+		}
+
+		if label.Section == it.section && !ref.got {
+			disp := int64(label.Offset) + fixup.Addend - int64(fixup.Offset)
+			binary.LittleEndian.PutUint32(field, uint32(disp))
+
+			return nil
+		}
+	case ref.mem || signed:
+		fixup.Kind = FixupAbsSigned
+	}
+
+	if size != 4 && size != 8 {
+		return newError(InvalidOperand, "label cannot be referred to by a field of this size")
+	}
+
+	cg.fixups = append(cg.fixups, fixup)
+
+	return nil
 }
 
-func (cg CodeGen) getCurrentSectOff() uint64 {
-	return cg.sectPos[cg.section]
+// refLabel returns the label the value refers to. Positions, like '$', are
+// referred to without a label, relative to the start of their section, so they
+// can only be referred to by the items in the same section.
+func refLabel(it *item, val value) (string, error) {
+	if val.sect != "" && val.sect != it.section {
+		return "", newError(InvalidOperand, "position in another section cannot be referred to")
+	}
+
+	return val.label, nil
 }
 
-func toOperand(t Token) (x86.Operand, error) {
-	// TODO: Add other operands.
-	switch t.ID() {
-	case Decimal:
-		i, err := strconv.ParseUint(t.Raw(), 10, 64)
+func (cg *CodeGen) toOperand(it *item, op Operand) (x86.Operand, *labelRef, error) {
+	switch op.ID {
+	case RegOperand:
+		return x86.Register(op.Expr.Tok.SpecID()), nil, nil
+	case ImmOperand:
+		val, err := cg.evalNode(op.Expr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(val.regs) != 0 {
+			return nil, nil, newError(InvalidOperand, "registers can only be used in memory operands")
+		}
+
+		if !val.isRelocatable() {
+			return x86.Immediate(val.n), nil, nil
+		}
+
+		name, err := refLabel(it, val)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return x86.Immediate(relocPlaceholder), &labelRef{label: name, addend: val.n}, nil
+	case MemOperand:
+		val, err := cg.evalNode(op.Expr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if op.Rel {
+			val.regs = append(val.regs, regTerm{x86.RIP, 1})
+		}
+
+		var ref *labelRef
+		if val.isRelocatable() {
+			name, err := refLabel(it, val)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			ref = &labelRef{label: name, addend: val.n, mem: true}
+			val.n = relocPlaceholder
+		}
+
+		addr, err := val.toAddress()
+		addr.Size = op.Size
+		if ref != nil {
+			ref.rel = addr.Base == x86.RIP
+			ref.got = op.GOT != nil
+		}
+
+		if err == nil && op.GOT != nil && (ref == nil || ref.label == "" || !ref.rel) {
+			err = newError(InvalidOperand, "GOT entry can only be referred to by a RIP-relative label address")
+		}
 
-		return x86.Immediate(i), err
-	case Register:
-		return x86.Register(t.SpecID()), nil
+		return addr, ref, err
 	}
 
-	return nil, errors.New("not supported operand")
+	return nil, nil, newError(InvalidOperand, "not supported operand")
 }
@@ -1,13 +1,16 @@
 package rasm_test
 
 import (
+	"errors"
 	"io"
+	"path/filepath"
 	"reflect"
 	"slices"
 	"strings"
 	"testing"
 
 	"github.com/nilhiu/rei/rasm"
+	"github.com/nilhiu/rei/x86"
 )
 
 func TestCodeGen_Next(t *testing.T) {
@@ -41,14 +44,70 @@ func TestCodeGen_Next(t *testing.T) {
 		},
 		{
 			name:   "Keeps track of labels",
-			rd:     strings.NewReader("section .bss\nlabel:\nmov rax, 50123"),
-			labels: map[string]rasm.LabelInfo{"label": {".bss", 0}},
+			rd:     strings.NewReader("section .rodata\nlabel:\nmov rax, 50123"),
+			labels: map[string]rasm.LabelInfo{"label": {".rodata", 0, rasm.LocalBinding}},
 			want: []byte{
 				0x48, 0xB8, 0xCB, 0xC3, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 			},
-			want2:   ".bss",
+			want2:   ".rodata",
+			wantErr: false,
+		},
+		{
+			name:    "Generating code for a memory operand",
+			rd:      strings.NewReader("mov eax, [rbx + rcx*4 + 0x10]"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0x8b, 0x44, 0x8b, 0x10},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating code for a memory operand without registers",
+			rd:      strings.NewReader("mov [0x1000], ecx"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0x89, 0x0c, 0x25, 0x00, 0x10, 0x00, 0x00},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating code for a memory operand with a scaled index",
+			rd:      strings.NewReader("add rdx, [8*rsi - 8]"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0x48, 0x03, 0x14, 0xf5, 0xf8, 0xff, 0xff, 0xff},
+			want2:   ".text",
 			wantErr: false,
 		},
+		{
+			name:    "Invalid address scale should give an error",
+			rd:      strings.NewReader("mov eax, [rbx + rcx*3]"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Label referred to before it's defined",
+			rd:      strings.NewReader("lea rax, [rel label]\nlabel:"),
+			labels:  map[string]rasm.LabelInfo{"label": {".text", 7, rasm.LocalBinding}},
+			want:    []byte{0x48, 0x8d, 0x05, 0x00, 0x00, 0x00, 0x00},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Undefined label should give an error",
+			rd:      strings.NewReader("mov eax, label"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Branch to a constant should give an error",
+			rd:      strings.NewReader("jmp 5"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
 		{
 			name:    "EOF should give nil slice without error",
 			rd:      strings.NewReader(""),
@@ -65,10 +124,330 @@ func TestCodeGen_Next(t *testing.T) {
 			want2:   ".text",
 			wantErr: true,
 		},
+		{
+			name:    "Generating code for every kind of number literal",
+			rd:      strings.NewReader("db 0xff, 0o17, 0b101, 0FFh, 17q, 101b, 1_0, 0x1_0, 0d12, 12d, 0b, 0d"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0xff, 0x0f, 0x05, 0xff, 0x0f, 0x05, 0x0a, 0x10, 0x0c, 0x0c, 0x00, 0x00},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating code for a hexadecimal immediate",
+			rd:      strings.NewReader("mov eax, 0xff"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0xb8, 0xff, 0x00, 0x00, 0x00},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating code for character constants",
+			rd:      strings.NewReader("mov eax, 'ABCD'"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0xb8, 0x41, 0x42, 0x43, 0x44},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating code for character constants in expressions",
+			rd:      strings.NewReader("db 'a' - 'A', `\\n` + 1"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0x20, 0x0b},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Character constants longer than 8 bytes should give an error",
+			rd:      strings.NewReader("mov rax, 'ABCDEFGHI'"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Generating code for a sized memory operand",
+			rd:      strings.NewReader("mov dword [rbx], 5"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0xc7, 0x03, 0x05, 0x00, 0x00, 0x00},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating code for a sized memory operand (with ptr)",
+			rd:      strings.NewReader("add byte ptr [rbx + 1], -1"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0x80, 0x43, 0x01, 0xff},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Memory operand without a size should give an error",
+			rd:      strings.NewReader("mov [rbx], 5"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Memory operand size not matching the register should give an error",
+			rd:      strings.NewReader("mov eax, word [rbx]"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Generating code for repeated data",
+			rd:      strings.NewReader("times 3 db 1, 2"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0x01, 0x02, 0x01, 0x02, 0x01, 0x02},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating code for repeated instructions",
+			rd:      strings.NewReader("times 2 mov eax, 1"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0xb8, 0x01, 0x00, 0x00, 0x00, 0xb8, 0x01, 0x00, 0x00, 0x00},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Negative times count should give an error",
+			rd:      strings.NewReader("times 1 - 2 db 0"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Generating code for a negative immediate",
+			rd:      strings.NewReader("add rax, -1"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0x48, 0x83, 0xc0, 0xff},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Immediate out of the register's range should give an error",
+			rd:      strings.NewReader("mov al, 300"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Generating code for constant expressions",
+			rd:      strings.NewReader("mov eax, (1 << 12) | 3 + 100 / 7 % 5 - (0xff ^ ~0) & 255"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0xb8, 0x07, 0x10, 0x00, 0x00},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating code for a logical right shift",
+			rd:      strings.NewReader("db -1 >> 60, -8 / 2"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0x0f, 0xfc},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating code for a memory operand with a constant expression",
+			rd:      strings.NewReader("mov ebx, [rbp - 8*(1 + 1)]"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0x8b, 0x5d, 0xf0},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Non-constant operand of a bitwise operator should give an error",
+			rd:      strings.NewReader("label:\nmov eax, label | 1"),
+			labels:  map[string]rasm.LabelInfo{"label": {".text", 0, rasm.LocalBinding}},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Division by zero should give an error",
+			rd:      strings.NewReader("mov eax, 1 / (2 - 2)"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Negative reservation count should give an error",
+			rd:      strings.NewReader("resb 2 - 3"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Generating data with strings",
+			rd:      strings.NewReader("db `hi\\n`, 0, `\\x41\\101`, 'a\\', \"b\\n\""),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{'h', 'i', '\n', 0, 'A', 'A', 'a', '\\', 'b', '\\', 'n'},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating data with padded strings",
+			rd:      strings.NewReader("dd 'abcde', 4660"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{'a', 'b', 'c', 'd', 'e', 0, 0, 0, 0x34, 0x12, 0, 0},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating data wider than 64 bits",
+			rd:      strings.NewReader("dt 1"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Data which doesn't fit its unit should give an error",
+			rd:      strings.NewReader("dw 0x10000"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "GOT entry of an absolute address should give an error",
+			rd:      strings.NewReader("mov rax, [puts wrt ..gotpcrel]\nputs:"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Label in a byte should give an error",
+			rd:      strings.NewReader("label:\ndb label"),
+			labels:  map[string]rasm.LabelInfo{"label": {".text", 0, rasm.LocalBinding}},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Reservation too large for a section with data should give an error",
+			rd:      strings.NewReader("section .data\nresb 4611686018427387904"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".data",
+			wantErr: true,
+		},
+		{
+			name:    "Reservation overflowing the section size should give an error",
+			rd:      strings.NewReader("section .bss\nresq 2305843009213693952"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".bss",
+			wantErr: true,
+		},
+		{
+			name:    "Reserving space in a section with data",
+			rd:      strings.NewReader("resw 2"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0, 0, 0, 0},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Data in a section without data should give an error",
+			rd:      strings.NewReader("section .bss\ndb 1"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".bss",
+			wantErr: true,
+		},
+		{
+			name:    "Generating code for constants",
+			rd:      strings.NewReader("SIZE equ COUNT * 4\nCOUNT equ 3\nmov eax, SIZE + 1"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0xb8, 0x0d, 0x00, 0x00, 0x00},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Generating code for reassigned constants",
+			rd:      strings.NewReader("%assign i 1\n%assign i i << 4\nmov eax, [rbx + i]\n%assign i 0"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    []byte{0x8b, 0x43, 0x10},
+			want2:   ".text",
+			wantErr: false,
+		},
+		{
+			name:    "Redefining an equ constant should give an error",
+			rd:      strings.NewReader("A equ 1\n%assign A 2"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Constant defined in terms of itself should give an error",
+			rd:      strings.NewReader("A equ B\nB equ A + 1\nmov eax, A"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Using a constant before it's assigned should give an error",
+			rd:      strings.NewReader("mov eax, i\n%assign i 1"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Label with the name of a constant should give an error",
+			rd:      strings.NewReader("A equ 1\nA:"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Exporting a constant depending on a label should give an error",
+			rd:      strings.NewReader("global A\nA equ label\nlabel:"),
+			labels:  map[string]rasm.LabelInfo{"label": {".text", 0, rasm.LocalBinding}},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
 		{
 			name:    "Label redefinition should give an error",
 			rd:      strings.NewReader("label:\nlabel:\n"),
-			labels:  map[string]rasm.LabelInfo{"label": {".text", 0}},
+			labels:  map[string]rasm.LabelInfo{"label": {".text", 0, rasm.LocalBinding}},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Global label which is never defined should give an error",
+			rd:      strings.NewReader("global label\nmov eax, 1"),
+			labels:  map[string]rasm.LabelInfo{},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Defining an extern label should give an error",
+			rd:      strings.NewReader("extern label\nlabel:\n"),
+			labels:  map[string]rasm.LabelInfo{"label": {"", 0, rasm.ExternBinding}},
+			want:    nil,
+			want2:   ".text",
+			wantErr: true,
+		},
+		{
+			name:    "Conflicting bindings should give an error",
+			rd:      strings.NewReader("global label\nstatic label\nlabel:\n"),
+			labels:  map[string]rasm.LabelInfo{"label": {".text", 0, rasm.GlobalBinding}},
 			want:    nil,
 			want2:   ".text",
 			wantErr: true,
@@ -112,18 +491,18 @@ func TestCodeGen(t *testing.T) {
   section .data
   mov_code:
     mov eax, 25
-  section .bss
+  section .rodata
   add_code:
     add edx, ebx
   section .text
   _start:
     mov eax, 60
     mov ebx, 0`
-	wantSects := []string{".text", ".data", ".bss", ".text", ".text"}
+	wantSects := []string{".text", ".data", ".rodata", ".text", ".text"}
 	wantLabels := map[string]rasm.LabelInfo{
-		"mov_code": {".data", 0},
-		"add_code": {".bss", 0},
-		"_start":   {".text", 5},
+		"mov_code": {".data", 0, rasm.LocalBinding},
+		"add_code": {".rodata", 0, rasm.LocalBinding},
+		"_start":   {".text", 5, rasm.LocalBinding},
 	}
 	wantCode := []byte{
 		0xbb, 0x01, 0x00, 0x00, 0x00,
@@ -156,3 +535,817 @@ func TestCodeGen(t *testing.T) {
 		t.Errorf("Next() = %x, want %x", gotCode, wantCode)
 	}
 }
+
+// assembleAll returns the code generated by all the calls to [rasm.CodeGen.Next],
+// failing the test if any of them fails.
+func assembleAll(t *testing.T, cg *rasm.CodeGen) []byte {
+	t.Helper()
+
+	code := []byte{}
+	for {
+		bytes, _, err := cg.Next()
+		if err != nil {
+			t.Fatalf("Next() failed: %v", err)
+		}
+
+		if bytes == nil {
+			return code
+		}
+
+		code = append(code, bytes...)
+	}
+}
+
+func TestCodeGenLabelReferences(t *testing.T) {
+	prog := `
+  section .text
+  _start:
+    mov rax, msg
+    lea rsi, [msg + 4]
+    lea rdi, [rel after]
+    mov ecx, [rel msg]
+  after:
+    add ebx, after
+  section .data
+  msg:
+    mov eax, 1
+    dq msg, 0
+    dd after`
+	wantCode := []byte{
+		0x48, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x48, 0x8d, 0x34, 0x25, 0x00, 0x00, 0x00, 0x00,
+		0x48, 0x8d, 0x3d, 0x06, 0x00, 0x00, 0x00,
+		0x8b, 0x0d, 0x00, 0x00, 0x00, 0x00,
+		0x81, 0xc3, 0x00, 0x00, 0x00, 0x00,
+		0xb8, 0x01, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	wantFixups := []rasm.Fixup{
+		{rasm.FixupAbs, ".text", 2, 8, "msg", 0},
+		{rasm.FixupAbsSigned, ".text", 14, 4, "msg", 4},
+		{rasm.FixupRel, ".text", 27, 4, "msg", -4},
+		{rasm.FixupAbs, ".text", 33, 4, "after", 0},
+		{rasm.FixupAbs, ".data", 5, 8, "msg", 0},
+		{rasm.FixupAbs, ".data", 21, 4, "after", 0},
+	}
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	gotCode := assembleAll(t, cg)
+
+	if !slices.Equal(wantCode, gotCode) {
+		t.Errorf("Next() = %x, want %x", gotCode, wantCode)
+	}
+
+	if !reflect.DeepEqual(wantFixups, cg.Fixups()) {
+		t.Errorf("cg.Fixups() = %v, want %v", cg.Fixups(), wantFixups)
+	}
+}
+
+func TestCodeGenBranchRelaxation(t *testing.T) {
+	prog := "start:\njmp end\nje start\n" + strings.Repeat("mov eax, 1\n", 26) +
+		"end:\njnz start\njz next\nnext:\njmp elsewhere\nsection .other\nelsewhere:"
+	wantCode := []byte{0xe9, 0x84, 0x00, 0x00, 0x00, 0x74, 0xf9}
+	for i := 0; i < 26; i++ {
+		wantCode = append(wantCode, 0xb8, 0x01, 0x00, 0x00, 0x00)
+	}
+	wantCode = append(wantCode, 0x0f, 0x85, 0x71, 0xff, 0xff, 0xff, 0x74, 0x00)
+	wantCode = append(wantCode, 0xe9, 0x00, 0x00, 0x00, 0x00)
+	wantFixups := []rasm.Fixup{{rasm.FixupBranch, ".text", 146, 4, "elsewhere", -4}}
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	gotCode := assembleAll(t, cg)
+
+	if !slices.Equal(wantCode, gotCode) {
+		t.Errorf("Next() = %x, want %x", gotCode, wantCode)
+	}
+
+	if !reflect.DeepEqual(wantFixups, cg.Fixups()) {
+		t.Errorf("cg.Fixups() = %v, want %v", cg.Fixups(), wantFixups)
+	}
+}
+
+func TestCodeGenGOTReferences(t *testing.T) {
+	prog := "mov rax, [rel puts wrt ..gotpcrel]\nlocal:\nmov rcx, [rel local wrt ..gotpcrel]\nsection .other\nputs:"
+	wantCode := []byte{
+		0x48, 0x8b, 0x05, 0x00, 0x00, 0x00, 0x00,
+		0x48, 0x8b, 0x0d, 0x00, 0x00, 0x00, 0x00,
+	}
+	wantFixups := []rasm.Fixup{
+		{rasm.FixupGOTRel, ".text", 3, 4, "puts", -4},
+		{rasm.FixupGOTRel, ".text", 10, 4, "local", -4},
+	}
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	gotCode := assembleAll(t, cg)
+
+	if !slices.Equal(gotCode, wantCode) {
+		t.Errorf("Next() = %x, want %x", gotCode, wantCode)
+	}
+
+	if !reflect.DeepEqual(cg.Fixups(), wantFixups) {
+		t.Errorf("cg.Fixups() = %v, want %v", cg.Fixups(), wantFixups)
+	}
+}
+
+func TestCodeGenBindings(t *testing.T) {
+	prog := `
+  global _start, exit
+  extern puts
+  static loop
+  _start:
+    call puts
+  loop:
+    jmp loop
+  exit:
+    mov rax, printf
+  extern printf`
+	wantLabels := map[string]rasm.LabelInfo{
+		"_start": {".text", 0, rasm.GlobalBinding},
+		"loop":   {".text", 5, rasm.LocalBinding},
+		"exit":   {".text", 7, rasm.GlobalBinding},
+		"puts":   {"", 0, rasm.ExternBinding},
+		"printf": {"", 0, rasm.ExternBinding},
+	}
+	wantFixups := []rasm.Fixup{
+		{rasm.FixupBranch, ".text", 1, 4, "puts", -4},
+		{rasm.FixupAbs, ".text", 9, 8, "printf", 0},
+	}
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	assembleAll(t, cg)
+
+	if !reflect.DeepEqual(wantLabels, cg.Labels()) {
+		t.Errorf("cg.Labels() = %v, want %v", cg.Labels(), wantLabels)
+	}
+
+	if !reflect.DeepEqual(wantFixups, cg.Fixups()) {
+		t.Errorf("cg.Fixups() = %v, want %v", cg.Fixups(), wantFixups)
+	}
+}
+
+func TestCodeGenSections(t *testing.T) {
+	prog := `
+  section .data
+    dd 1
+    resw 3
+  section .bss
+  buf:
+    resb 4096
+  section .text
+    mov eax, 1
+  section .bss.big
+    resq 0x100000
+  section .bss
+    resd 2`
+	wantSects := []rasm.SectionInfo{
+		{".data", 10, false},
+		{".bss", 4104, true},
+		{".text", 5, false},
+		{".bss.big", 0x800000, true},
+	}
+	wantLabels := map[string]rasm.LabelInfo{"buf": {".bss", 0, rasm.LocalBinding}}
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	for {
+		bytes, sect, err := cg.Next()
+		if err != nil {
+			t.Fatal("Next() failed with an error: ", err)
+		}
+
+		if bytes == nil {
+			break
+		}
+
+		if rasm.IsNoBits(sect) && len(bytes) != 0 {
+			t.Errorf("Next() = %x in %s, want no bytes", bytes, sect)
+		}
+	}
+
+	if !reflect.DeepEqual(wantSects, cg.Sections()) {
+		t.Errorf("cg.Sections() = %v, want %v", cg.Sections(), wantSects)
+	}
+
+	if !reflect.DeepEqual(wantLabels, cg.Labels()) {
+		t.Errorf("cg.Labels() = %v, want %v", cg.Labels(), wantLabels)
+	}
+}
+
+func TestCodeGenExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rd      io.Reader
+		wantErr string
+	}{
+		{
+			name:    "Non-constant operand of a shift",
+			rd:      strings.NewReader("label:\nmov eax, 1 << label"),
+			wantErr: "2:12: operator '<<' expects constant operands",
+		},
+		{
+			name:    "Non-constant operand of a complement",
+			rd:      strings.NewReader("mov eax, [~rbx]"),
+			wantErr: "1:11: operator '~' expects a constant operand",
+		},
+		{
+			name:    "Undefined label",
+			rd:      strings.NewReader("mov eax, 2 * (1 + nowhere)"),
+			wantErr: "1:19: undefined label 'nowhere'",
+		},
+		{
+			name:    "Error in the definition of a constant",
+			rd:      strings.NewReader("A equ 1 / 0\nmov eax, A"),
+			wantErr: "1:9: division by zero",
+		},
+		{
+			name:    "Constant used before it's assigned",
+			rd:      strings.NewReader("mov eax, 1 + i\n%assign i 1"),
+			wantErr: "1:14: constant is used before it's assigned",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cg := rasm.NewCodeGen(tt.rd)
+
+			_, _, err := cg.Next()
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Next() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCodeGenConstants(t *testing.T) {
+	prog := `
+  global SYS_WRITE
+  SYS_WRITE equ 1
+  STDOUT: equ SYS_WRITE
+  OFFSET equ msg + 4
+  %assign i 0
+  %assign i i + 1
+  FLAGS equ (i > 0) | (i <= -1) << 1 | !0 << 2 | (i == 1 && 2 != 2 ^^ 1) << 3
+  msg:`
+	wantConsts := map[string]rasm.ConstInfo{
+		"SYS_WRITE": {1, rasm.GlobalBinding},
+		"STDOUT":    {1, rasm.LocalBinding},
+		"i":         {1, rasm.LocalBinding},
+		"FLAGS":     {13, rasm.LocalBinding},
+	}
+	wantLabels := map[string]rasm.LabelInfo{"msg": {".text", 0, rasm.LocalBinding}}
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	if _, _, err := cg.Next(); err != nil {
+		t.Fatal("Next() failed with an error: ", err)
+	}
+
+	if !reflect.DeepEqual(wantConsts, cg.Constants()) {
+		t.Errorf("cg.Constants() = %v, want %v", cg.Constants(), wantConsts)
+	}
+
+	if !reflect.DeepEqual(wantLabels, cg.Labels()) {
+		t.Errorf("cg.Labels() = %v, want %v", cg.Labels(), wantLabels)
+	}
+}
+
+func TestCodeGenLocalLabels(t *testing.T) {
+	prog := `
+  .orphan:
+  first:
+  .loop:
+    jmp .loop
+    .end equ LAST - 1
+  second:
+  .loop:
+    jne .loop
+    jmp first.loop
+  LAST equ 4`
+	wantCode := []byte{0xeb, 0xfe, 0x75, 0xfe, 0xeb, 0xfa}
+	wantLabels := map[string]rasm.LabelInfo{
+		".orphan":     {".text", 0, rasm.LocalBinding},
+		"first":       {".text", 0, rasm.LocalBinding},
+		"first.loop":  {".text", 0, rasm.LocalBinding},
+		"second":      {".text", 2, rasm.LocalBinding},
+		"second.loop": {".text", 2, rasm.LocalBinding},
+	}
+	wantConsts := map[string]rasm.ConstInfo{
+		"first.end": {3, rasm.LocalBinding},
+		"LAST":      {4, rasm.LocalBinding},
+	}
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	gotCode := assembleAll(t, cg)
+
+	if !slices.Equal(wantCode, gotCode) {
+		t.Errorf("Next() = %x, want %x", gotCode, wantCode)
+	}
+
+	if !reflect.DeepEqual(wantLabels, cg.Labels()) {
+		t.Errorf("cg.Labels() = %v, want %v", cg.Labels(), wantLabels)
+	}
+
+	if !reflect.DeepEqual(wantConsts, cg.Constants()) {
+		t.Errorf("cg.Constants() = %v, want %v", cg.Constants(), wantConsts)
+	}
+}
+
+func TestCodeGenErrors(t *testing.T) {
+	prog := "mov eax, [rbx,\nlabel:\nlabel:\nmov eax, (1\nmov eax, 1\njmp nowhere"
+	wantErrs := []string{
+		"1:14: expected ']', found ','",
+		"3:1: label already exists",
+		"4:12: expected ')', found end of line",
+		"6:5: undefined label 'nowhere'",
+	}
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	gotErrs := []string{}
+	for _, err := range cg.Errors() {
+		gotErrs = append(gotErrs, err.Error())
+	}
+
+	if !slices.Equal(wantErrs, gotErrs) {
+		t.Errorf("cg.Errors() = %q, want %q", gotErrs, wantErrs)
+	}
+
+	wantCode := []byte{0xb8, 0x01, 0x00, 0x00, 0x00}
+	gotCode := []byte{}
+	for {
+		bytes, _, err := cg.Next()
+		if err != nil {
+			continue
+		}
+
+		if bytes == nil {
+			break
+		}
+
+		gotCode = append(gotCode, bytes...)
+	}
+
+	if !slices.Equal(wantCode, gotCode) {
+		t.Errorf("Next() = %x, want %x", gotCode, wantCode)
+	}
+}
+
+func TestCodeGenErrorKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		rd      io.Reader
+		want    rasm.Error
+		wantX86 error
+	}{
+		{
+			name: "Duplicate label",
+			rd:   strings.NewReader("label:\nlabel:"),
+			want: rasm.Error{Kind: rasm.DuplicateLabel, Pos: rasm.Position{2, 0}, End: rasm.Position{2, 5}},
+		},
+		{
+			name: "Unknown mnemonic",
+			rd:   strings.NewReader("foo eax, 1"),
+			want: rasm.Error{Kind: rasm.UnknownMnemonic, Pos: rasm.Position{1, 0}, End: rasm.Position{1, 3}},
+		},
+		{
+			name:    "Operand mismatch",
+			rd:      strings.NewReader("lea eax, 5"),
+			want:    rasm.Error{Kind: rasm.OperandMismatch, Pos: rasm.Position{1, 0}, End: rasm.Position{1, 10}},
+			wantX86: x86.ErrOperandMismatch,
+		},
+		{
+			name: "Undefined label",
+			rd:   strings.NewReader("mov rax, [nowhere + 8]"),
+			want: rasm.Error{Kind: rasm.UndefinedLabel, Pos: rasm.Position{1, 10}, End: rasm.Position{1, 17}},
+		},
+		{
+			name: "GOT entry of an absolute address",
+			rd:   strings.NewReader("mov rax, [puts wrt ..gotpcrel]\nputs:"),
+			want: rasm.Error{Kind: rasm.InvalidOperand, Pos: rasm.Position{1, 0}, End: rasm.Position{1, 30}},
+		},
+		{
+			name:    "Out of range immediate",
+			rd:      strings.NewReader("add eax, 1 << 32"),
+			want:    rasm.Error{Kind: rasm.OutOfRange, Pos: rasm.Position{1, 9}, End: rasm.Position{1, 16}},
+			wantX86: x86.ErrOutOfRange,
+		},
+		{
+			name: "Labels in different sections subtracted",
+			rd:   strings.NewReader("a:\nsection .data\nb:\ndd b - a"),
+			want: rasm.Error{Kind: rasm.NotConstant, Pos: rasm.Position{4, 5}, End: rasm.Position{4, 6}},
+		},
+		{
+			name: "Position in another section",
+			rd:   strings.NewReader("section .data\nhere equ $\nsection .text\nmov eax, here"),
+			want: rasm.Error{Kind: rasm.InvalidOperand, Pos: rasm.Position{4, 0}, End: rasm.Position{4, 13}},
+		},
+		{
+			name: "Undefined label in macro expansion",
+			rd:   strings.NewReader("%define ARG(n) [nowhere + n]\nmov eax, ARG(8)"),
+			want: rasm.Error{Kind: rasm.UndefinedLabel, Pos: rasm.Position{2, 9}, End: rasm.Position{2, 15}},
+		},
+		{
+			name: "Malformed macro definition",
+			rd:   strings.NewReader("%define F(a\n"),
+			want: rasm.Error{Kind: rasm.SyntaxError, Pos: rasm.Position{1, 11}, End: rasm.Position{1, 12}},
+		},
+		{
+			name: "Out of range data",
+			rd:   strings.NewReader("db 1, 256"),
+			want: rasm.Error{Kind: rasm.OutOfRange, Pos: rasm.Position{1, 0}, End: rasm.Position{1, 9}},
+		},
+		{
+			name: "Times count over the limit",
+			rd:   strings.NewReader("times 1 << 40 db 0"),
+			want: rasm.Error{Kind: rasm.OutOfRange, Pos: rasm.Position{1, 6}, End: rasm.Position{1, 13}},
+		},
+		{
+			name: "Repeated reservation overflowing the section size",
+			rd:   strings.NewReader("section .bss\ntimes 1000000 resq 1 << 50"),
+			want: rasm.Error{Kind: rasm.OutOfRange, Pos: rasm.Position{2, 6}, End: rasm.Position{2, 13}},
+		},
+		{
+			name: "Reservation too large for a section with data",
+			rd:   strings.NewReader("section .data\nresb 1 << 62"),
+			want: rasm.Error{Kind: rasm.OutOfRange, Pos: rasm.Position{2, 5}, End: rasm.Position{2, 12}},
+		},
+		{
+			name: "Reservation overflowing the section size",
+			rd:   strings.NewReader("section .bss\nresq 1 << 61"),
+			want: rasm.Error{Kind: rasm.OutOfRange, Pos: rasm.Position{2, 5}, End: rasm.Position{2, 12}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cg := rasm.NewCodeGen(tt.rd)
+
+			errs := cg.Errors()
+			if len(errs) != 1 {
+				t.Fatalf("cg.Errors() = %v, want a single error", errs)
+			}
+
+			var got *rasm.Error
+			if !errors.As(errs[0], &got) {
+				t.Fatalf("cg.Errors()[0] = %v, want a *rasm.Error", errs[0])
+			}
+
+			if got.Kind != tt.want.Kind || got.Pos != tt.want.Pos || got.End != tt.want.End {
+				t.Errorf(
+					"cg.Errors()[0] = %v %v-%v, want %v %v-%v",
+					got.Kind, got.Pos, got.End, tt.want.Kind, tt.want.Pos, tt.want.End,
+				)
+			}
+
+			if tt.wantX86 != nil && !errors.Is(got, tt.wantX86) {
+				t.Errorf("cg.Errors()[0] = %v, want it to wrap %v", got, tt.wantX86)
+			}
+		})
+	}
+}
+
+func TestCodeGenErrorFile(t *testing.T) {
+	lxr := rasm.NewLexerFile("prog.asm", strings.NewReader("mov eax, 1\njmp nowhere"))
+	cg := rasm.NewCodeGenParser(rasm.NewParserLexer(lxr))
+
+	want := "prog.asm:2:5: undefined label 'nowhere'"
+	if errs := cg.Errors(); len(errs) != 1 || errs[0].Error() != want {
+		t.Errorf("cg.Errors() = %v, want [%s]", errs, want)
+	}
+}
+
+func TestCodeGenIncludedErrorFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "defs.inc")
+	writeFile(t, path, "mov eax, [\njmp nowhere\n")
+
+	lxr := rasm.NewLexerFile("prog.asm", strings.NewReader("%include \""+path+"\"\nmov eax, ["))
+	cg := rasm.NewCodeGenParser(rasm.NewParserLexer(lxr))
+
+	want := []string{
+		path + ":1:11: expected register or number, found end of line",
+		path + ":2:5: undefined label 'nowhere'",
+		"prog.asm:2:11: expected register or number, found end of file",
+	}
+	got := []string{}
+
+	for _, err := range cg.Errors() {
+		got = append(got, err.Error())
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("cg.Errors() = %q, want %q", got, want)
+	}
+}
+
+func TestCodeGenIncbin(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "blob.bin"), "\x01\x02\x03\x04\x05")
+	writeFile(t, filepath.Join(dir, "lib", "table.bin"), "\xaa\xbb")
+
+	prog := `
+  section .data
+  blob:
+    incbin "blob.bin"
+    incbin "blob.bin", 3
+    incbin "table.bin", OFFSET, 1
+  blob_end:
+  OFFSET equ 1
+  blob_len equ blob_end - blob`
+	wantCode := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x04, 0x05, 0xbb}
+
+	lxr := rasm.NewLexerFile(filepath.Join(dir, "prog.asm"), strings.NewReader(prog))
+	pp := rasm.NewPreprocessor(lxr)
+	pp.SetIncludePaths([]string{filepath.Join(dir, "lib")})
+
+	cg := rasm.NewCodeGenParser(rasm.NewParserPreprocessor(pp))
+	gotCode := assembleAll(t, cg)
+
+	if !slices.Equal(gotCode, wantCode) {
+		t.Errorf("Next() = %x, want %x", gotCode, wantCode)
+	}
+
+	if got := cg.Constants()["blob_len"].Value; got != 8 {
+		t.Errorf("cg.Constants()[\"blob_len\"].Value = %d, want 8", got)
+	}
+}
+
+func TestCodeGenIncbinErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "blob.bin"), "\x01\x02\x03\x04")
+
+	prog := "incbin \"blob.bin\", 5\nincbin \"blob.bin\", 1, 4\nincbin \"missing.bin\"\nincbin 1\nincbin \"blob.bin\", -1\n" +
+		"section .bss\nincbin \"blob.bin\", 4\n"
+	wantErrs := []string{
+		"1:20: offset is past the end of the file, which has 4 bytes",
+		"2:23: length is past the end of the file, which has 3 bytes left",
+		"3:8: cannot find included file 'missing.bin'",
+		"4:1: incbin expects a file name, and an optional offset and length",
+		"5:20: incbin offset cannot be negative",
+		"7:1: section without data can only reserve space",
+	}
+
+	lxr := rasm.NewLexer(strings.NewReader(prog))
+	pp := rasm.NewPreprocessor(lxr)
+	pp.SetIncludePaths([]string{dir})
+
+	gotErrs := []string{}
+	for _, err := range rasm.NewCodeGenParser(rasm.NewParserPreprocessor(pp)).Errors() {
+		gotErrs = append(gotErrs, err.Error())
+	}
+
+	if !slices.Equal(wantErrs, gotErrs) {
+		t.Errorf("cg.Errors() = %q, want %q", gotErrs, wantErrs)
+	}
+}
+
+func TestCodeGenReps(t *testing.T) {
+	prog := `
+  %macro isr_stub 1
+    mov eax, %1
+    jmp common
+  %endmacro
+
+  STUB_SIZE equ 10
+  stubs:
+  %assign i 0
+  %rep 256
+    isr_stub i
+  %assign i i + 1
+  %endrep
+  common:
+  section .data
+  %assign i 0
+  %rep 256
+    dq stubs + i * STUB_SIZE
+  %assign i i + 1
+  %endrep`
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	code := map[string][]byte{}
+
+	for {
+		bytes, sect, err := cg.Next()
+		if err != nil {
+			t.Fatalf("Next() failed: %v", err)
+		}
+
+		if bytes == nil {
+			break
+		}
+
+		code[sect] = append(code[sect], bytes...)
+	}
+
+	if got := len(code[".text"]); got != 256*10 {
+		t.Fatalf("len(.text) = %d, want %d", got, 256*10)
+	}
+
+	// The last stub loads its number, and jumps right after itself.
+	wantLast := []byte{0xb8, 0xff, 0x00, 0x00, 0x00, 0xe9, 0x00, 0x00, 0x00, 0x00}
+	if got := code[".text"][255*10:]; !slices.Equal(got, wantLast) {
+		t.Errorf("last stub = %x, want %x", got, wantLast)
+	}
+
+	fixups := cg.Fixups()
+	if len(fixups) != 256 {
+		t.Fatalf("len(cg.Fixups()) = %d, want 256", len(fixups))
+	}
+
+	if got := fixups[255]; got.Label != "stubs" || got.Offset != 255*8 || got.Addend != 255*10 {
+		t.Errorf("cg.Fixups()[255] = %v, want a reference to stubs + 2550 at 2040", got)
+	}
+}
+
+func TestCodeGenRedefinitionNotes(t *testing.T) {
+	tests := []struct {
+		name string
+		rd   io.Reader
+		want []rasm.Note
+	}{
+		{
+			name: "Redefined label should note its previous definition",
+			rd:   strings.NewReader("\n  start:\nstart:"),
+			want: []rasm.Note{{
+				Pos: rasm.Position{2, 2},
+				End: rasm.Position{2, 7},
+				Msg: "previous definition of label here",
+			}},
+		},
+		{
+			name: "Label in macro expansion should note the invocation",
+			rd:   strings.NewReader("%macro m 0\nx:\n%endmacro\nm\nm"),
+			want: []rasm.Note{
+				{Pos: rasm.Position{2, 0}, End: rasm.Position{2, 1}, Msg: "previous definition of label here"},
+				{Pos: rasm.Position{5, 0}, End: rasm.Position{5, 1}, Msg: "in expansion of macro 'm'"},
+			},
+		},
+		{
+			name: "Label named after a constant should note the constant",
+			rd:   strings.NewReader("SIZE equ 4\nSIZE:"),
+			want: []rasm.Note{{
+				Pos: rasm.Position{1, 0},
+				End: rasm.Position{1, 4},
+				Msg: "previous definition of constant here",
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := rasm.NewCodeGen(tt.rd).Errors()
+
+			var got *rasm.Error
+			if len(errs) != 1 || !errors.As(errs[0], &got) {
+				t.Fatalf("cg.Errors() = %v, want a single *rasm.Error", errs)
+			}
+
+			if got.Kind != rasm.DuplicateLabel || !reflect.DeepEqual(got.Notes, tt.want) {
+				t.Errorf("cg.Errors()[0] = %v %v, want %v %v", got.Kind, got.Notes, rasm.DuplicateLabel, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeGenSizedMemoryReferences(t *testing.T) {
+	prog := "mov dword [rel msg], 5\nmov qword [rbx], msg\nsection .data\nmsg:"
+	wantCode := []byte{
+		0xc7, 0x05, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00,
+		0x48, 0xc7, 0x03, 0x00, 0x00, 0x00, 0x00,
+	}
+	wantFixups := []rasm.Fixup{
+		{rasm.FixupRel, ".text", 2, 4, "msg", -8},
+		{rasm.FixupAbsSigned, ".text", 13, 4, "msg", 0},
+	}
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	gotCode := assembleAll(t, cg)
+
+	if !slices.Equal(gotCode, wantCode) {
+		t.Errorf("Next() = %x, want %x", gotCode, wantCode)
+	}
+
+	if !reflect.DeepEqual(cg.Fixups(), wantFixups) {
+		t.Errorf("cg.Fixups() = %v, want %v", cg.Fixups(), wantFixups)
+	}
+}
+
+func TestCodeGenTimes(t *testing.T) {
+	prog := `
+  start:
+    times 2 jmp start
+    times 0 db 0xff
+    times SIZE db 0
+  end:
+  SIZE equ 4 - 2
+  section .bss
+    times 3 resw 2
+  buf:
+    times 1000000 resq 1 << 40
+  huge:`
+	wantCode := []byte{0xeb, 0xfe, 0xeb, 0xfc, 0x00, 0x00}
+	wantLabels := map[string]rasm.LabelInfo{
+		"start": {".text", 0, rasm.LocalBinding},
+		"end":   {".text", 6, rasm.LocalBinding},
+		"buf":   {".bss", 12, rasm.LocalBinding},
+		"huge":  {".bss", 12 + 1000000*(8<<40), rasm.LocalBinding},
+	}
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	gotCode := assembleAll(t, cg)
+
+	if !slices.Equal(gotCode, wantCode) {
+		t.Errorf("Next() = %x, want %x", gotCode, wantCode)
+	}
+
+	if !reflect.DeepEqual(cg.Labels(), wantLabels) {
+		t.Errorf("cg.Labels() = %v, want %v", cg.Labels(), wantLabels)
+	}
+}
+
+func TestCodeGenPositions(t *testing.T) {
+	prog := `
+  section .data
+  msg:
+    db "hello"
+  msg_len equ $ - msg
+    dq $
+  section .text
+  start:
+    jmp $
+    mov eax, msg_len
+    mov ecx, end - start
+    times 16 - ($ - $$) db 0x90
+  end:`
+	wantCode := []byte{
+		'h', 'e', 'l', 'l', 'o',
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xeb, 0xfe,
+		0xb8, 0x05, 0x00, 0x00, 0x00,
+		0xb9, 0x10, 0x00, 0x00, 0x00,
+		0x90, 0x90, 0x90, 0x90,
+	}
+	wantFixups := []rasm.Fixup{{rasm.FixupAbs, ".data", 5, 8, "", 5}}
+	wantConsts := map[string]rasm.ConstInfo{"msg_len": {5, rasm.LocalBinding}}
+
+	cg := rasm.NewCodeGen(strings.NewReader(prog))
+	gotCode := assembleAll(t, cg)
+
+	if !slices.Equal(gotCode, wantCode) {
+		t.Errorf("Next() = %x, want %x", gotCode, wantCode)
+	}
+
+	if !reflect.DeepEqual(cg.Fixups(), wantFixups) {
+		t.Errorf("cg.Fixups() = %v, want %v", cg.Fixups(), wantFixups)
+	}
+
+	if !reflect.DeepEqual(cg.Constants(), wantConsts) {
+		t.Errorf("cg.Constants() = %v, want %v", cg.Constants(), wantConsts)
+	}
+
+	if got := cg.Labels()["end"].Offset; got != 16 {
+		t.Errorf("cg.Labels()[\"end\"].Offset = %d, want 16", got)
+	}
+}
+
+func TestCodeGenConditionalErrors(t *testing.T) {
+	prog := "%ifdef X\nmov eax, 1\n%else\n%else\n%endif\n%elif 1\n%if x\n%endif\n%if 1\n%ifdef\n"
+	wantErrs := []string{
+		"4:1: %else after %else",
+		"6:1: %elif without %if",
+		"7:5: expected constant, found 'x'",
+		"10:7: expected macro name, found end of line",
+		"9:1: %if without %endif",
+		"10:1: %ifdef without %endif",
+	}
+
+	gotErrs := []string{}
+	for _, err := range rasm.NewCodeGen(strings.NewReader(prog)).Errors() {
+		gotErrs = append(gotErrs, err.Error())
+	}
+
+	if !slices.Equal(wantErrs, gotErrs) {
+		t.Errorf("cg.Errors() = %q, want %q", gotErrs, wantErrs)
+	}
+}
+
+func TestCodeGenMacroNotes(t *testing.T) {
+	src := "%macro inner 1\nmov eax, %1\n%endmacro\n%macro outer 0\ninner nowhere\n%endmacro\nouter"
+	wantNotes := []rasm.Note{
+		{Pos: rasm.Position{5, 0}, End: rasm.Position{5, 13}, Msg: "in expansion of macro 'inner'"},
+		{Pos: rasm.Position{7, 0}, End: rasm.Position{7, 5}, Msg: "in expansion of macro 'outer'"},
+	}
+
+	errs := rasm.NewCodeGen(strings.NewReader(src)).Errors()
+
+	var got *rasm.Error
+	if len(errs) != 1 || !errors.As(errs[0], &got) {
+		t.Fatalf("cg.Errors() = %v, want a single *rasm.Error", errs)
+	}
+
+	if got.Kind != rasm.UndefinedLabel || got.Pos != (rasm.Position{5, 6}) {
+		t.Errorf("cg.Errors()[0] = %v at %v, want %v at %v", got.Kind, got.Pos, rasm.UndefinedLabel, rasm.Position{5, 6})
+	}
+
+	if !reflect.DeepEqual(got.Notes, wantNotes) {
+		t.Errorf("cg.Errors()[0].Notes = %v, want %v", got.Notes, wantNotes)
+	}
+}
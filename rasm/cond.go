@@ -0,0 +1,281 @@
+package rasm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A condFrame represents a conditional block, started by one of the %if
+// directives, and ended by its %endif.
+type condFrame struct {
+	dir Token // the directive starting the block, which its errors point to
+	// active reports if the current branch of the block is assembled, while
+	// taken reports if any of its branches was.
+	active bool
+	taken  bool
+	elsed  bool // reports if the block reached its %else
+}
+
+// A condKind represents the condition tested by a conditional directive.
+type condKind uint
+
+const (
+	exprCond      condKind = iota // tests if a constant expression isn't zero
+	definedCond                   // tests if a single-line macro is defined
+	undefinedCond                 // tests if a single-line macro isn't defined
+	identCond                     // tests if two texts are identical
+	identFoldCond                 // tests if two texts are identical, ignoring case
+	macroCond                     // tests if a multi-line macro is defined
+)
+
+// ifConds contains the conditions tested by the directives which start a
+// conditional block, while elifConds contains the ones tested by the
+// directives which start its next branch.
+var (
+	ifConds = map[DirectiveID]condKind{
+		IfDir:      exprCond,
+		IfdefDir:   definedCond,
+		IfndefDir:  undefinedCond,
+		IfidnDir:   identCond,
+		IfidniDir:  identFoldCond,
+		IfmacroDir: macroCond,
+	}
+	elifConds = map[DirectiveID]condKind{
+		ElifDir:      exprCond,
+		ElifdefDir:   definedCond,
+		ElifndefDir:  undefinedCond,
+		ElifidnDir:   identCond,
+		ElifidniDir:  identFoldCond,
+		ElifmacroDir: macroCond,
+	}
+)
+
+// isConditional reports if the token is a directive of a conditional block.
+func isConditional(tok Token) bool {
+	if tok.ID() != Directive {
+		return false
+	}
+
+	id := DirectiveID(tok.SpecID())
+	_, isIf := ifConds[id]
+	_, isElif := elifConds[id]
+
+	return isIf || isElif || id == ElseDir || id == EndifDir
+}
+
+// skipping reports if the lines are in a branch of a conditional block which
+// isn't assembled. The blocks nested in such a branch are never active.
+func (pp *Preprocessor) skipping() bool {
+	return len(pp.conds) != 0 && !pp.conds[len(pp.conds)-1].active
+}
+
+// conditional handles the line of a conditional directive. The directives are
+// handled even in skipped branches, so the blocks nested in them are matched
+// with their %endif.
+func (pp *Preprocessor) conditional(line []Token) []Token {
+	dir, end := line[0], line[len(line)-1]
+	name := strings.ToLower(dir.Raw())
+	id := DirectiveID(dir.SpecID())
+
+	var err *Error
+
+	if kind, ok := ifConds[id]; ok {
+		frame := condFrame{dir: dir, taken: true}
+		if !pp.skipping() {
+			frame.active, err = pp.test(kind, line)
+			frame.taken = frame.active || err != nil
+		}
+
+		pp.conds = append(pp.conds, frame)
+
+		return condResult(err, end)
+	}
+
+	if len(pp.conds) == 0 {
+		return errorLine(tokenError(dir, name+" without %if"), end)
+	}
+
+	frame := &pp.conds[len(pp.conds)-1]
+	kind, isElif := elifConds[id]
+
+	switch {
+	case id == EndifDir:
+		pp.conds = pp.conds[:len(pp.conds)-1]
+		err = expectEnd(line[1])
+	case frame.elsed:
+		err = tokenError(dir, name+" after %else")
+	case id == ElseDir:
+		frame.active, frame.taken, frame.elsed = !frame.taken, true, true
+		err = expectEnd(line[1])
+	case isElif:
+		frame.active = false
+		if !frame.taken {
+			frame.active, err = pp.test(kind, line)
+			frame.taken = frame.active || err != nil
+		}
+	}
+
+	return condResult(err, end)
+}
+
+func condResult(err *Error, end Token) []Token {
+	if err != nil {
+		return errorLine(err, end)
+	}
+
+	return []Token{end}
+}
+
+// test tests the condition of a conditional directive line. A branch whose
+// condition is malformed isn't assembled, and neither are the rest of its
+// block's branches.
+func (pp *Preprocessor) test(kind condKind, line []Token) (bool, *Error) {
+	switch kind {
+	case definedCond, undefinedCond, macroCond:
+		name := line[1]
+		if !isName(name) {
+			return false, tokenError(name, "expected macro name, found "+describe(name))
+		} else if err := expectEnd(line[2]); err != nil {
+			return false, err
+		}
+
+		switch kind {
+		case definedCond:
+			return pp.lookup(name) != nil, nil
+		case undefinedCond:
+			return pp.lookup(name) == nil, nil
+		}
+
+		return pp.lookupMulti(name) != nil, nil
+	}
+
+	// The macros are expanded in the expressions and texts.
+	toks, err := pp.expand(line[1 : len(line)-1])
+	if err != nil {
+		return false, err
+	}
+
+	if kind == exprCond {
+		n, err := pp.evalExpr(toks, line[len(line)-1])
+		return n != 0, err
+	}
+
+	texts := splitList(toks, 2)
+	if len(texts) != 2 {
+		msg := fmt.Sprintf("%s expects two texts separated by a comma", strings.ToLower(line[0].Raw()))
+		return false, tokenError(line[0], msg)
+	}
+
+	return sameText(texts[0], texts[1], kind == identFoldCond), nil
+}
+
+// evalExpr parses and evaluates the constant expression of a directive, like
+// a condition. The tokens are followed by the line's end.
+func (pp *Preprocessor) evalExpr(toks []Token, end Token) (int64, *Error) {
+	p := &Parser{src: &tokenList{toks: toks, end: end}}
+
+	tok := p.next()
+	if tok.ID() == Newline || tok.ID() == EOF {
+		return 0, tokenError(tok, "expected expression, found "+describe(tok))
+	}
+
+	root, bad := p.parseExpr(tok, 0)
+	if bad != nil {
+		return 0, bad.Err
+	} else if err := expectEnd(p.read()); err != nil {
+		return 0, err
+	}
+
+	val, err := pp.evalPreprocNode(root)
+	if err != nil {
+		var srcErr *Error
+		errors.As(err, &srcErr)
+
+		return 0, srcErr
+	}
+
+	return val.n, nil
+}
+
+// evalPreprocNode evaluates the expression tree of a directive, which can only
+// contain constants, as labels aren't known while preprocessing. The values of
+// the %assign constants known so far can be used too.
+func (pp *Preprocessor) evalPreprocNode(n *Node) (value, error) {
+	if n.Left == nil {
+		var val value
+		var err error
+
+		assigned, isAssigned := pp.assigns[n.Tok.Raw()]
+
+		switch {
+		case n.Tok.ID() == Identifier && isAssigned:
+			val.n = assigned
+		case n.Tok.ID() == Decimal, n.Tok.ID() == Hex, n.Tok.ID() == Octal, n.Tok.ID() == Binary:
+			val.n, err = parseNumber(n.Tok)
+		case n.Tok.ID() == String:
+			val.n, err = charConst(n.Tok)
+		default:
+			err = newError(NotConstant, "expected constant, found "+describe(n.Tok))
+		}
+
+		if err != nil {
+			return value{}, locate(err, n.Tok.File(), n.Tok.Pos(), tokenEnd(n.Tok))
+		}
+
+		return val, nil
+	}
+
+	left, err := pp.evalPreprocNode(n.Left)
+	if err != nil {
+		return value{}, err
+	}
+
+	var val value
+	if n.Right == nil {
+		val, err = evalUnary(n.Tok.Raw(), left)
+	} else {
+		var right value
+		if right, err = pp.evalPreprocNode(n.Right); err != nil {
+			return value{}, err
+		}
+
+		val, err = evalBinary(n.Tok.Raw(), left, right)
+	}
+
+	if err != nil {
+		return value{}, locate(err, n.Tok.File(), n.Tok.Pos(), tokenEnd(n.Tok))
+	}
+
+	return val, nil
+}
+
+// sameText reports if the two lists of tokens have the same text, optionally
+// ignoring its case. The tokens' kinds are compared too, as the raw strings of
+// numbers don't include their prefixes.
+func sameText(a, b []Token, fold bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].ID() != b[i].ID() {
+			return false
+		} else if fold && !strings.EqualFold(a[i].Raw(), b[i].Raw()) {
+			return false
+		} else if !fold && a[i].Raw() != b[i].Raw() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// expectEnd returns an error unless the token ends the line.
+func expectEnd(tok Token) *Error {
+	if tok.ID() == Newline || tok.ID() == EOF {
+		return nil
+	}
+
+	return tokenError(tok, "expected end of line, found "+describe(tok))
+}
@@ -0,0 +1,130 @@
+package rasm
+
+// A constant represents a symbolic constant, defined by the equ, or %assign,
+// directive.
+type constant struct {
+	// expr is the value of an equ constant, which is evaluated whenever the
+	// constant is used, so it may refer to labels, or constants, defined after
+	// it.
+	expr  *Node
+	scope string // the scope of the local labels referred to by the value
+	item  int    // the index of the defining item, whose position '$' refers to
+	// redefinable reports if the constant is defined by %assign. Its value is
+	// set when the directive is laid out, and only seen by the code after it.
+	redefinable bool
+	n           int64
+	set         bool
+	binding     Binding
+	evaluating  bool
+}
+
+// A ConstInfo represents information about a symbolic constant.
+type ConstInfo struct {
+	Value   int64   // the value of the constant
+	Binding Binding // the visibility of the constant outside of the source
+}
+
+// Constants returns a map of names to information of the symbolic constants
+// defined in the code. Constants whose values depend on labels are omitted.
+func (cg *CodeGen) Constants() map[string]ConstInfo {
+	consts := map[string]ConstInfo{}
+	for name, c := range cg.consts {
+		val, err := cg.evalConst(c)
+		if err == nil && val.isConst() {
+			consts[name] = ConstInfo{Value: val.n, Binding: c.binding}
+		}
+	}
+
+	return consts
+}
+
+// addConst handles a constant definition when it's first encountered.
+func (cg *CodeGen) addConst(expr Expr) error {
+	name := expr.Children[0].Raw()
+	if DirectiveID(expr.Root.SpecID()) == EquDir {
+		name = cg.qualify(name)
+	}
+
+	if len(expr.Operands) != 1 || expr.Operands[0].ID != ImmOperand {
+		return newError(InvalidDirective, "constant definition expects a single value")
+	}
+
+	if _, ok := cg.labels[name]; ok {
+		return cg.redefinition(name, "name is already defined")
+	}
+
+	c, ok := cg.consts[name]
+	if DirectiveID(expr.Root.SpecID()) == AssignDir {
+		if ok && !c.redefinable {
+			return cg.redefinition(name, "constant cannot be redefined")
+		} else if !ok {
+			cg.consts[name] = &constant{redefinable: true}
+			cg.defs[name] = expr.Children[0]
+		}
+
+		return nil
+	}
+
+	if ok {
+		return cg.redefinition(name, "name is already defined")
+	}
+
+	cg.consts[name] = &constant{expr: expr.Operands[0].Expr, scope: cg.scope, item: len(cg.items)}
+	cg.defs[name] = expr.Children[0]
+
+	return nil
+}
+
+// assign sets the value of an %assign constant, as it's laid out.
+func (cg *CodeGen) assign(it *item) error {
+	val, err := cg.evalNode(it.expr.Operands[0].Expr)
+	if err != nil {
+		return err
+	} else if !val.isConst() {
+		return newError(NotConstant, "%assign expects a constant value")
+	}
+
+	c := cg.consts[it.expr.Children[0].Raw()]
+	c.n, c.set = val.n, true
+
+	return nil
+}
+
+func (cg *CodeGen) evalConst(c *constant) (value, error) {
+	if c.redefinable {
+		if !c.set {
+			return value{}, newError(UndefinedLabel, "constant is used before it's assigned")
+		}
+
+		return value{n: c.n}, nil
+	}
+
+	if c.evaluating {
+		return value{}, newError(InvalidDirective, "constant is defined in terms of itself")
+	}
+
+	scope, here := cg.scope, cg.here
+	c.evaluating, cg.scope, cg.here = true, c.scope, &cg.items[c.item]
+	defer func() { c.evaluating, cg.scope, cg.here = false, scope, here }()
+
+	return cg.evalNode(c.expr)
+}
+
+// checkConstExports reports an error for the exported constants, whose values
+// aren't known, as they depend on labels.
+func (cg *CodeGen) checkConstExports() {
+	for _, c := range cg.consts {
+		if c.binding != GlobalBinding {
+			continue
+		}
+
+		if val, err := cg.evalConst(c); err != nil || !val.isConst() {
+			cg.items = append(cg.items, item{
+				section: cg.section,
+				err:     newError(NotConstant, "exported constant must not depend on labels"),
+			})
+
+			return
+		}
+	}
+}
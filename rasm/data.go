@@ -0,0 +1,353 @@
+package rasm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDataSize is the maximum size, in bytes, of a section with data, whose
+// bytes are all stored, unlike the ones of a section which only reserves space.
+const maxDataSize = 1 << 30
+
+// dataSizes maps the data directives to the size, in bytes, of their units.
+var dataSizes = map[DirectiveID]int{
+	DbDir: 1,
+	DwDir: 2,
+	DdDir: 4,
+	DqDir: 8,
+	DtDir: 10,
+	DoDir: 16,
+}
+
+// reserveSizes maps the reservation directives to the size, in bytes, of
+// their units.
+var reserveSizes = map[DirectiveID]int{
+	ResbDir: 1,
+	ReswDir: 2,
+	ResdDir: 4,
+	ResqDir: 8,
+}
+
+// IsNoBits reports if the named section only reserves space, without
+// containing any data, such as ".bss".
+func IsNoBits(section string) bool {
+	for _, name := range []string{".bss", ".tbss"} {
+		if section == name || strings.HasPrefix(section, name+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxSectionSize returns the maximum size, in bytes, of the named section.
+func maxSectionSize(section string) uint64 {
+	if IsNoBits(section) {
+		return math.MaxUint64
+	}
+
+	return maxDataSize
+}
+
+// genData generates the data of a data directive, whose units are of the given
+// size. Strings are padded with zeros to a multiple of the unit size.
+func (cg *CodeGen) genData(it *item, size int) ([]byte, error) {
+	if len(it.expr.Operands) == 0 {
+		return nil, newError(InvalidDirective, "data directive expects at least one operand")
+	}
+
+	data := []byte{}
+	for _, op := range it.expr.Operands {
+		if op.ID != ImmOperand {
+			return nil, newError(InvalidDirective, "data directive expects numbers, strings or labels")
+		}
+
+		if op.Expr.Left == nil && op.Expr.Tok.ID() == String {
+			str, err := unquote(op.Expr.Tok.Raw())
+			if err != nil {
+				return nil, err
+			}
+
+			data = append(data, str...)
+			if rem := len(str) % size; rem != 0 {
+				data = append(data, make([]byte, size-rem)...)
+			}
+
+			continue
+		}
+
+		val, err := cg.evalNode(op.Expr)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(val.regs) != 0 {
+			return nil, newError(InvalidOperand, "registers cannot be used as data")
+		}
+
+		if val.isRelocatable() {
+			if size != 4 && size != 8 {
+				return nil, newError(InvalidOperand, "label cannot be referred to by a field of this size")
+			}
+
+			name, err := refLabel(it, val)
+			if err != nil {
+				return nil, err
+			}
+
+			cg.fixups = append(cg.fixups, Fixup{
+				Kind:    FixupAbs,
+				Section: it.section,
+				Offset:  it.offset + uint64(len(data)),
+				Size:    uint(size),
+				Label:   name,
+				Addend:  val.n,
+			})
+			val.n = 0
+		}
+
+		unit, err := encodeUnit(val.n, size)
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, unit...)
+	}
+
+	return data, nil
+}
+
+// genReserve reserves space for the units of a reservation directive, whose
+// count is given as its operand. The reserved space is filled with zeros, unless
+// the section has no data, in which case it's only added to the item's size.
+func (cg *CodeGen) genReserve(it *item, size int) ([]byte, error) {
+	if len(it.expr.Operands) != 1 || it.expr.Operands[0].ID != ImmOperand {
+		return nil, newError(InvalidDirective, "reservation directive expects a single count")
+	}
+
+	count := it.expr.Operands[0].Expr
+
+	val, err := cg.evalNode(count)
+	switch {
+	case err != nil:
+	case !val.isConst():
+		err = newError(NotConstant, "reservation count must be a constant")
+	case val.n < 0:
+		err = newError(OutOfRange, "reservation count cannot be negative")
+	case uint64(val.n) > (maxSectionSize(it.section)-it.offset)/uint64(size):
+		limit := maxSectionSize(it.section)
+		err = newError(OutOfRange, fmt.Sprintf("reserved space exceeds the maximum section size of %d bytes", limit))
+	}
+
+	if err != nil {
+		pos, end := nodeSpan(count)
+		return nil, locate(err, count.Tok.File(), pos, end)
+	}
+
+	it.reserved = uint64(val.n) * uint64(size)
+	if IsNoBits(it.section) {
+		return []byte{}, nil
+	}
+
+	code := make([]byte, it.reserved)
+	it.reserved = 0
+
+	return code, nil
+}
+
+// genIncbin generates the bytes of the file included by an incbin directive,
+// starting at its optional offset, and limited to its optional length. The
+// file is searched for like the ones included by %include.
+func (cg *CodeGen) genIncbin(it *item) ([]byte, error) {
+	ops := it.expr.Operands
+	if len(ops) == 0 || len(ops) > 3 || ops[0].ID != ImmOperand || ops[0].Expr.Tok.ID() != String {
+		return nil, newError(InvalidDirective, "incbin expects a file name, and an optional offset and length")
+	}
+
+	nameTok := ops[0].Expr.Tok
+	data, err := cg.readFile(nameTok, it.expr.Root.File())
+	if err != nil {
+		return nil, locate(err, nameTok.File(), nameTok.Pos(), tokenEnd(nameTok))
+	}
+
+	size := uint64(len(data))
+	offset, length := uint64(0), size
+
+	for i, op := range ops[1:] {
+		what := [2]string{"offset", "length"}[i]
+
+		val, err := cg.evalNode(op.Expr)
+		switch {
+		case err != nil:
+		case !val.isConst():
+			err = newError(NotConstant, "incbin "+what+" must be a constant")
+		case val.n < 0:
+			err = newError(OutOfRange, "incbin "+what+" cannot be negative")
+		case i == 0 && uint64(val.n) > size:
+			err = newError(OutOfRange, fmt.Sprintf("offset is past the end of the file, which has %s", plural(int(size), "byte")))
+		case i == 1 && uint64(val.n) > length:
+			err = newError(OutOfRange, fmt.Sprintf("length is past the end of the file, which has %s left", plural(int(length), "byte")))
+		}
+
+		if err != nil {
+			pos, end := nodeSpan(op.Expr)
+			return nil, locate(err, op.Expr.Tok.File(), pos, end)
+		}
+
+		if i == 0 {
+			offset, length = uint64(val.n), size-uint64(val.n)
+		} else {
+			length = uint64(val.n)
+		}
+	}
+
+	return append([]byte{}, data[offset:offset+length]...), nil
+}
+
+// readFile returns the contents of the file named by the string token, which
+// is included by the given file.
+func (cg *CodeGen) readFile(name Token, from string) ([]byte, error) {
+	bs, err := unquote(name.Raw())
+	if err != nil {
+		return nil, err
+	}
+
+	path, ok := cg.p.pp.resolve(string(bs), from)
+	if !ok {
+		return nil, newError(InvalidDirective, fmt.Sprintf("cannot find included file '%s'", bs))
+	}
+
+	if data, ok := cg.files[path]; ok {
+		return data, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+
+		return nil, newError(InvalidDirective, fmt.Sprintf("cannot read '%s': %v", path, err))
+	}
+
+	cg.files[path] = data
+
+	return data, nil
+}
+
+// encodeUnit encodes the number as a little-endian unit of the given size.
+// Units wider than 8 bytes are sign-extended.
+func encodeUnit(n int64, size int) ([]byte, error) {
+	if size < 8 {
+		bits := uint(size * 8)
+		if n < -(1<<(bits-1)) || n > (1<<bits)-1 {
+			return nil, newError(OutOfRange, "value does not fit in the data unit")
+		}
+	}
+
+	unit := binary.LittleEndian.AppendUint64(make([]byte, 0, max(size, 8)), uint64(n))
+	for len(unit) < size {
+		unit = append(unit, byte(n>>63))
+	}
+
+	return unit[:size], nil
+}
+
+// unquote returns the bytes of a quoted string token. Like in NASM, single and
+// double quoted strings are taken as is, while backquoted strings have their
+// escape sequences replaced.
+func unquote(raw string) ([]byte, error) {
+	str := raw[1 : len(raw)-1]
+	if raw[0] != '`' {
+		return []byte(str), nil
+	}
+
+	out := []byte{}
+	for i := 0; i < len(str); i++ {
+		if str[i] != '\\' {
+			out = append(out, str[i])
+			continue
+		}
+
+		i++
+		if i == len(str) {
+			return nil, newError(SyntaxError, "unterminated escape sequence")
+		}
+
+		switch c := str[i]; c {
+		case 'a':
+			out = append(out, '\a')
+		case 'b':
+			out = append(out, '\b')
+		case 'e':
+			out = append(out, 0x1B)
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'v':
+			out = append(out, '\v')
+		case '\\', '\'', '"', '`', '?':
+			out = append(out, c)
+		case 'x':
+			n, digits := escapeDigits(str[i+1:], 16, 2)
+			if digits == 0 {
+				return nil, newError(SyntaxError, "\\x escape sequence expects hexadecimal digits")
+			}
+
+			out = append(out, byte(n))
+			i += digits
+		case 'u', 'U':
+			width := 4
+			if c == 'U' {
+				width = 8
+			}
+
+			n, digits := escapeDigits(str[i+1:], 16, width)
+			if digits != width || !utf8.ValidRune(rune(n)) {
+				return nil, newError(SyntaxError, "invalid unicode escape sequence")
+			}
+
+			out = utf8.AppendRune(out, rune(n))
+			i += digits
+		default:
+			n, digits := escapeDigits(str[i:], 8, 3)
+			if digits == 0 {
+				return nil, newError(SyntaxError, "unknown escape sequence")
+			}
+
+			out = append(out, byte(n))
+			i += digits - 1
+		}
+	}
+
+	return out, nil
+}
+
+// escapeDigits parses at most maxDigits leading digits of the given base,
+// returning their value and the number of digits parsed.
+func escapeDigits(str string, base int, maxDigits int) (uint64, int) {
+	digits := 0
+	for digits < len(str) && digits < maxDigits {
+		if _, err := strconv.ParseUint(str[digits:digits+1], base, 8); err != nil {
+			break
+		}
+
+		digits++
+	}
+
+	n, _ := strconv.ParseUint(str[:digits], base, 64)
+
+	return n, digits
+}
@@ -0,0 +1,222 @@
+package rasm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nilhiu/rei/x86"
+)
+
+// An ErrorKind represents the kind of an [Error], so it can be checked without
+// relying on the error's message.
+type ErrorKind uint
+
+const (
+	SyntaxError      ErrorKind = iota // represents a malformed expression
+	UnknownMnemonic                   // represents an unknown mnemonic, or directive
+	DuplicateLabel                    // represents a label, or constant, defined more than once
+	UndefinedLabel                    // represents a reference to an undefined label, or constant
+	OperandMismatch                   // represents operands unsupported by the instruction
+	InvalidOperand                    // represents an operand which can't be encoded
+	OutOfRange                        // represents a value which doesn't fit in its encoding
+	NotConstant                       // represents a value which isn't constant, but has to be
+	InvalidDirective                  // represents a directive with invalid operands, or usage
+	LayoutError                       // represents code whose label offsets never settle
+)
+
+var errorKindNames = map[ErrorKind]string{
+	SyntaxError:      "syntax error",
+	UnknownMnemonic:  "unknown mnemonic",
+	DuplicateLabel:   "duplicate label",
+	UndefinedLabel:   "undefined label",
+	OperandMismatch:  "operand mismatch",
+	InvalidOperand:   "invalid operand",
+	OutOfRange:       "out of range",
+	NotConstant:      "not constant",
+	InvalidDirective: "invalid directive",
+	LayoutError:      "layout error",
+}
+
+// String returns a short description of the error kind.
+func (k ErrorKind) String() string {
+	return errorKindNames[k]
+}
+
+// An Error represents an error in the assembly source, located at a span of
+// it. Errors of the x86 package are wrapped in it, so they can still be
+// checked using [errors.Is].
+type Error struct {
+	Kind ErrorKind
+	File string   // the name of the source file, if it's known
+	Pos  Position // the start of the erroneous span of the source
+	End  Position // the position right after the end of the erroneous span
+	Msg  string   // the message of the error, if it doesn't wrap another error
+	Err  error    // the wrapped error
+	// Notes contains additional information about the error, such as where a
+	// redefined label was first defined.
+	Notes []Note
+}
+
+// A Note represents additional information about an [Error], located at a span
+// of the source.
+type Note struct {
+	File string // the name of the source file, if it's known
+	Pos  Position
+	End  Position
+	Msg  string
+}
+
+// Error returns the message of the error, prefixed by its location, as a
+// one-based line and column.
+func (e *Error) Error() string {
+	msg := e.Message()
+
+	if e.Pos.Line == 0 {
+		if e.File != "" {
+			return e.File + ": " + msg
+		}
+
+		return msg
+	}
+
+	loc := fmt.Sprintf("%d:%d", e.Pos.Line, e.Pos.Col+1)
+	if e.File != "" {
+		loc = e.File + ":" + loc
+	}
+
+	return loc + ": " + msg
+}
+
+// Message returns the message of the error, without its location.
+func (e *Error) Message() string {
+	if e.Msg == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+
+	return e.Msg
+}
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(kind ErrorKind, msg string) *Error {
+	return &Error{Kind: kind, Msg: msg}
+}
+
+// locate returns the error as an [*Error] located at the given span of the
+// file, unless it's already located somewhere else, like in the definition of a
+// constant.
+func locate(err error, file string, pos, end Position) error {
+	var srcErr *Error
+	if !errors.As(err, &srcErr) {
+		return &Error{Kind: kindOf(err), File: file, Pos: pos, End: end, Err: err}
+	}
+
+	if srcErr.Pos.Line != 0 {
+		return err
+	}
+
+	located := *srcErr
+	located.File, located.Pos, located.End = file, pos, end
+
+	return &located
+}
+
+// kindOf returns the kind of an error returned by the x86 package.
+func kindOf(err error) ErrorKind {
+	switch {
+	case errors.Is(err, x86.ErrUnknownMnemonic):
+		return UnknownMnemonic
+	case errors.Is(err, x86.ErrOperandMismatch):
+		return OperandMismatch
+	case errors.Is(err, x86.ErrOutOfRange):
+		return OutOfRange
+	}
+
+	return InvalidOperand
+}
+
+// tokenEnd returns the position right after the end of the token in the source.
+// Tokens expanded from a macro cover the whole invocation.
+func tokenEnd(tok Token) Position {
+	if tok.end != (Position{}) {
+		return tok.end
+	}
+
+	end := tok.Pos()
+
+	switch tok.ID() {
+	case EOF:
+	case Newline, Illegal:
+		end.Col++
+	default:
+		end.Col += uint(len([]rune(tok.Raw())))
+	}
+
+	return end
+}
+
+// nodeSpan returns the span of the source covered by the expression tree.
+func nodeSpan(n *Node) (Position, Position) {
+	pos, end := n.Tok.Pos(), tokenEnd(n.Tok)
+
+	for _, child := range []*Node{n.Left, n.Right} {
+		if child == nil {
+			continue
+		}
+
+		childPos, childEnd := nodeSpan(child)
+		pos, end = minPos(pos, childPos), maxPos(end, childEnd)
+	}
+
+	return pos, end
+}
+
+// exprSpan returns the span of the source covered by the expression.
+func exprSpan(expr Expr) (Position, Position) {
+	pos, end := expr.Root.Pos(), tokenEnd(expr.Root)
+
+	for _, tok := range expr.Children {
+		pos, end = minPos(pos, tok.Pos()), maxPos(end, tokenEnd(tok))
+	}
+
+	if expr.Times != nil {
+		timesPos, timesEnd := nodeSpan(expr.Times)
+		pos, end = minPos(pos, timesPos), maxPos(end, timesEnd)
+	}
+
+	for _, op := range expr.Operands {
+		opPos, opEnd := nodeSpan(op.Expr)
+		if op.ID == MemOperand {
+			// Include the brackets of the address.
+			opPos = op.Root.Pos()
+			if op.GOT != nil {
+				opEnd = tokenEnd(*op.GOT)
+			}
+
+			opEnd.Col++
+		}
+
+		pos, end = minPos(pos, opPos), maxPos(end, opEnd)
+	}
+
+	return pos, end
+}
+
+func minPos(a, b Position) Position {
+	if a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col) {
+		return a
+	}
+
+	return b
+}
+
+func maxPos(a, b Position) Position {
+	if minPos(a, b) == a {
+		return b
+	}
+
+	return a
+}
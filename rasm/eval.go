@@ -0,0 +1,381 @@
+package rasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/nilhiu/rei/x86"
+)
+
+// A value represents the result of evaluating an operand's expression tree.
+// Besides a constant, it can hold registers multiplied by a factor, which
+// are used to form memory addresses, and a label whose address is added to it.
+// Positions, like '$', are held as the start of their section, to which the
+// constant is relative.
+type value struct {
+	n     int64
+	regs  []regTerm
+	label string
+	sect  string
+}
+
+type regTerm struct {
+	reg    x86.Register
+	factor int64
+}
+
+func (cg *CodeGen) evalNode(n *Node) (value, error) {
+	if n.Left == nil {
+		val, err := cg.evalLeaf(n.Tok)
+		if err != nil {
+			return value{}, locate(err, n.Tok.File(), n.Tok.Pos(), tokenEnd(n.Tok))
+		}
+
+		return val, nil
+	}
+
+	left, err := cg.evalNode(n.Left)
+	if err != nil {
+		return value{}, err
+	}
+
+	var val value
+	if n.Right == nil {
+		val, err = evalUnary(n.Tok.Raw(), left)
+	} else {
+		var right value
+		if right, err = cg.evalNode(n.Right); err != nil {
+			return value{}, err
+		}
+
+		// Subtracting labels, or positions, gives the distance between them.
+		if n.Tok.Raw() == "-" && left.isRelocatable() && right.isRelocatable() {
+			left, right, err = cg.toOffsets(left, right)
+		}
+
+		if err == nil {
+			val, err = evalBinary(n.Tok.Raw(), left, right)
+		}
+	}
+
+	if err != nil {
+		return value{}, locate(err, n.Tok.File(), n.Tok.Pos(), tokenEnd(n.Tok))
+	}
+
+	return val, nil
+}
+
+func evalUnary(op string, operand value) (value, error) {
+	switch op {
+	case "+":
+		return operand, nil
+	case "-":
+		return operand.mul(value{n: -1})
+	case "~":
+		if !operand.isConst() {
+			return value{}, newError(NotConstant, "operator '~' expects a constant operand")
+		}
+
+		return value{n: ^operand.n}, nil
+	case "!":
+		if !operand.isConst() {
+			return value{}, newError(NotConstant, "operator '!' expects a constant operand")
+		}
+
+		return boolValue(operand.n == 0), nil
+	}
+
+	return value{}, newError(SyntaxError, "unknown unary operator")
+}
+
+func evalBinary(op string, left, right value) (value, error) {
+	switch op {
+	case "+":
+		return left.add(right)
+	case "-":
+		neg, err := right.mul(value{n: -1})
+		if err != nil {
+			return value{}, err
+		}
+
+		return left.add(neg)
+	case "*":
+		if left.isConst() {
+			return right.mul(left)
+		}
+
+		return left.mul(right)
+	}
+
+	// The rest of the operators are only defined for constants.
+	if !left.isConst() || !right.isConst() {
+		return value{}, newError(NotConstant, fmt.Sprintf("operator '%s' expects constant operands", op))
+	}
+
+	a, b := left.n, right.n
+	switch op {
+	case "/", "%":
+		if b == 0 {
+			return value{}, newError(InvalidOperand, "division by zero")
+		}
+
+		if op == "/" {
+			return value{n: a / b}, nil
+		}
+
+		return value{n: a % b}, nil
+	case "<<", ">>":
+		if b < 0 {
+			return value{}, newError(OutOfRange, "shift count cannot be negative")
+		}
+
+		// Right shifts are logical, shifting in zeros.
+		if op == "<<" {
+			return value{n: a << b}, nil
+		}
+
+		return value{n: int64(uint64(a) >> b)}, nil
+	case "&":
+		return value{n: a & b}, nil
+	case "|":
+		return value{n: a | b}, nil
+	case "^":
+		return value{n: a ^ b}, nil
+	case "==", "=":
+		return boolValue(a == b), nil
+	case "!=", "<>":
+		return boolValue(a != b), nil
+	case "<":
+		return boolValue(a < b), nil
+	case "<=":
+		return boolValue(a <= b), nil
+	case ">":
+		return boolValue(a > b), nil
+	case ">=":
+		return boolValue(a >= b), nil
+	case "&&":
+		return boolValue(a != 0 && b != 0), nil
+	case "||":
+		return boolValue(a != 0 || b != 0), nil
+	case "^^":
+		return boolValue((a != 0) != (b != 0)), nil
+	}
+
+	return value{}, newError(SyntaxError, "unknown binary operator")
+}
+
+func (cg *CodeGen) evalLeaf(tok Token) (value, error) {
+	switch tok.ID() {
+	case Register:
+		return value{regs: []regTerm{{x86.Register(tok.SpecID()), 1}}}, nil
+	case Decimal, Hex, Octal, Binary:
+		n, err := parseNumber(tok)
+		return value{n: n}, err
+	case Identifier:
+		name := cg.qualify(tok.Raw())
+		if c, ok := cg.consts[name]; ok {
+			return cg.evalConst(c)
+		}
+
+		if _, ok := cg.labels[name]; !ok {
+			return value{}, newError(UndefinedLabel, fmt.Sprintf("undefined label '%s'", tok.Raw()))
+		}
+
+		return value{label: name}, nil
+	case Here:
+		if tok.Raw() == "$$" {
+			return value{sect: cg.here.section}, nil
+		}
+
+		return value{n: int64(cg.here.offset), sect: cg.here.section}, nil
+	case String:
+		n, err := charConst(tok)
+		return value{n: n}, err
+	}
+
+	return value{}, newError(InvalidOperand, "not supported operand")
+}
+
+// boolValue converts the result of a comparison, or logical operator, to a
+// value, which is 1 if it's true, and 0 otherwise.
+func boolValue(b bool) value {
+	if b {
+		return value{n: 1}
+	}
+
+	return value{}
+}
+
+// toOffsets converts two labels, or positions, which are subtracted from one
+// another, to their offsets, so their difference is a constant. This is only
+// possible if they're in the same section.
+func (cg *CodeGen) toOffsets(left, right value) (value, value, error) {
+	lsect, loff := cg.offsetOf(left)
+	rsect, roff := cg.offsetOf(right)
+	if lsect == "" || lsect != rsect {
+		return value{}, value{}, newError(NotConstant, "only labels in the same section can be subtracted")
+	}
+
+	left.n, left.label, left.sect = left.n+loff, "", ""
+	right.n, right.label, right.sect = right.n+roff, "", ""
+
+	return left, right, nil
+}
+
+// offsetOf returns the section of the value's label, or position, and its
+// offset from the section's start. Extern labels aren't in any section.
+func (cg *CodeGen) offsetOf(v value) (string, int64) {
+	if v.label == "" {
+		return v.sect, 0
+	}
+
+	label := cg.labels[v.label]
+	if label.Binding == ExternBinding {
+		return "", 0
+	}
+
+	return label.Section, int64(label.Offset)
+}
+
+// parseNumber converts a number token to its value.
+func parseNumber(tok Token) (int64, error) {
+	n, err := strconv.ParseUint(numberDigits(tok.ID(), tok.Raw()), numberBases[tok.ID()], 64)
+	if err != nil {
+		return 0, newError(OutOfRange, "number is too large")
+	}
+
+	return int64(n), nil
+}
+
+// charConst converts a string token to the value of its characters, the first
+// of which is the least significant byte, like when they're stored in memory.
+func charConst(tok Token) (int64, error) {
+	bs, err := unquote(tok.Raw())
+	if err != nil {
+		return 0, err
+	} else if len(bs) > 8 {
+		return 0, newError(OutOfRange, "character constant cannot be longer than 8 bytes")
+	}
+
+	var buf [8]byte
+	copy(buf[:], bs)
+
+	return int64(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+// add adds the two values together, merging the same registers.
+func (v value) add(other value) (value, error) {
+	if v.isRelocatable() && other.isRelocatable() {
+		return value{}, newError(InvalidOperand, "labels cannot be added together")
+	}
+
+	sum := value{n: v.n + other.n, label: v.label + other.label, sect: v.sect + other.sect}
+	sum.regs = append(sum.regs, v.regs...)
+
+	for _, term := range other.regs {
+		ix := sum.regIndex(term.reg)
+		if ix == -1 {
+			sum.regs = append(sum.regs, term)
+			continue
+		}
+
+		sum.regs[ix].factor += term.factor
+	}
+
+	return sum, nil
+}
+
+// mul multiplies the value by a constant value.
+func (v value) mul(c value) (value, error) {
+	if !c.isConst() {
+		return value{}, newError(NotConstant, "values can only be multiplied by constants")
+	}
+
+	if v.isRelocatable() && c.n != 1 {
+		return value{}, newError(InvalidOperand, "labels can only be added to or subtracted from")
+	}
+
+	prod := value{n: v.n * c.n, label: v.label, sect: v.sect}
+	for _, term := range v.regs {
+		prod.regs = append(prod.regs, regTerm{term.reg, term.factor * c.n})
+	}
+
+	return prod, nil
+}
+
+// isConst reports if the value is a constant, without registers or labels.
+func (v value) isConst() bool {
+	return len(v.regs) == 0 && !v.isRelocatable()
+}
+
+// isRelocatable reports if the value refers to a label, or a position, whose
+// address is only known once the code is relocated.
+func (v value) isRelocatable() bool {
+	return v.label != "" || v.sect != ""
+}
+
+func (v value) regIndex(reg x86.Register) int {
+	for i, term := range v.regs {
+		if term.reg == reg {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// toAddress converts the value to an [x86.Address]. A register multiplied by
+// one is prefered as the base, while the other one becomes the index.
+func (v value) toAddress() (x86.Address, error) {
+	addr := x86.Address{Scale: 1}
+	if v.n < -0x80000000 || v.n > 0x7FFFFFFF {
+		return addr, newError(OutOfRange, "address displacement out of range")
+	}
+	addr.Displacement = int32(v.n)
+
+	regs := []regTerm{}
+	for _, term := range v.regs {
+		if term.factor != 0 {
+			regs = append(regs, term)
+		}
+	}
+
+	switch len(regs) {
+	case 0:
+		return addr, nil
+	case 1:
+		if regs[0].factor == 1 {
+			addr.Base = regs[0].reg
+			return addr, nil
+		}
+
+		return addr, setIndex(&addr, regs[0])
+	case 2:
+		base, index := regs[0], regs[1]
+		if base.factor != 1 || index.reg == x86.RSP || index.reg == x86.ESP {
+			base, index = index, base
+		}
+
+		if base.factor != 1 {
+			return addr, newError(InvalidOperand, "address can only have one scaled register")
+		}
+
+		addr.Base = base.reg
+		return addr, setIndex(&addr, index)
+	}
+
+	return addr, newError(InvalidOperand, "address can only have two registers")
+}
+
+func setIndex(addr *x86.Address, term regTerm) error {
+	switch term.factor {
+	case 1, 2, 4, 8:
+		addr.Index = term.reg
+		addr.Scale = byte(term.factor)
+
+		return nil
+	}
+
+	return newError(InvalidOperand, "scale of an address must be 1, 2, 4 or 8")
+}
@@ -0,0 +1,193 @@
+package rasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxIncludeDepth is the maximum depth of nested included files.
+const maxIncludeDepth = 64
+
+// An includeFrame represents a file which includes another one, whose reading
+// is resumed once the included file has been fully read.
+type includeFrame struct {
+	lxr *Lexer
+	// pending contains the lines expanded from multi-line macros, which were
+	// still to be preprocessed when the file was included.
+	pending [][]Token
+}
+
+// SetIncludePaths sets the directories searched for the files included by the
+// %include and incbin directives, which aren't found relative to the including
+// file.
+func (pp *Preprocessor) SetIncludePaths(dirs []string) {
+	pp.includePaths = dirs
+}
+
+// lex returns the next token of the file being read. The end of an included
+// file ends its last line, after which the including file is read.
+func (pp *Preprocessor) lex() Token {
+	tok := pp.lxr.Next()
+	if tok.ID() != EOF || len(pp.includes) == 0 {
+		return tok
+	}
+
+	frame := pp.includes[len(pp.includes)-1]
+	pp.includes = pp.includes[:len(pp.includes)-1]
+	pp.lxr, pp.pending = frame.lxr, frame.pending
+
+	return Token{pos: tok.pos, file: tok.file, id: Newline, raw: "\\n"}
+}
+
+// include handles an %include directive line. The lines of the included file
+// are preprocessed right after it, followed by the rest of the current file.
+func (pp *Preprocessor) include(line []Token) []Token {
+	end := line[len(line)-1]
+	if end.ID() == EOF {
+		// The current file is read again once the included file ends.
+		end = Token{pos: end.pos, file: end.file, id: Newline, raw: "\\n"}
+	}
+
+	path, err := pp.includePath(line)
+	if err != nil {
+		return errorLine(err, end)
+	} else if path == "" {
+		return []Token{end}
+	}
+
+	src, readErr := os.ReadFile(path)
+	if readErr != nil {
+		var pathErr *os.PathError
+		if errors.As(readErr, &pathErr) {
+			readErr = pathErr.Err
+		}
+
+		return errorLine(tokenError(line[1], fmt.Sprintf("cannot read '%s': %v", path, readErr)), end)
+	}
+
+	lxr := NewLexerFile(path, bytes.NewReader(src))
+	lxr.SetCommentStyle(pp.lxr.comments)
+
+	pp.includes = append(pp.includes, includeFrame{lxr: pp.lxr, pending: pp.pending})
+	pp.lxr, pp.pending = lxr, nil
+
+	return []Token{end}
+}
+
+// includePath returns the path of the file included by an %include directive
+// line, or an empty path if it was already included, and has a once pragma.
+// Macros are expanded in the file's name.
+func (pp *Preprocessor) includePath(line []Token) (string, *Error) {
+	toks, err := pp.expand(line[1:])
+	if err != nil {
+		return "", err
+	}
+
+	name := toks[0]
+	if name.ID() != String {
+		return "", tokenError(name, "expected file name, found "+describe(name))
+	} else if err := expectEnd(toks[1]); err != nil {
+		return "", err
+	}
+
+	bs, unquoteErr := unquote(name.Raw())
+	if unquoteErr != nil {
+		return "", tokenError(name, unquoteErr.Error())
+	}
+
+	path, ok := pp.resolve(string(bs), pp.lxr.file)
+	switch {
+	case !ok:
+		return "", tokenError(name, fmt.Sprintf("cannot find included file '%s'", bs))
+	case pp.once[absPath(path)]:
+		return "", nil
+	case pp.isIncluding(path):
+		return "", tokenError(name, fmt.Sprintf("'%s' is included recursively", path))
+	case len(pp.includes) == maxIncludeDepth:
+		return "", tokenError(name, "included files are nested too deeply")
+	}
+
+	return path, nil
+}
+
+// resolve returns the path of a file included by another one, which is
+// searched for relative to the including file, and then in the include paths.
+func (pp *Preprocessor) resolve(name, from string) (string, bool) {
+	if filepath.IsAbs(name) {
+		return name, isFile(name)
+	}
+
+	dirs := append([]string{filepath.Dir(from)}, pp.includePaths...)
+	for _, dir := range dirs {
+		if path := filepath.Join(dir, name); isFile(path) {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
+// isIncluding reports if the file is being read, either as the current file,
+// or one of the files including it.
+func (pp *Preprocessor) isIncluding(path string) bool {
+	abs := absPath(path)
+	if absPath(pp.lxr.file) == abs {
+		return true
+	}
+
+	for _, frame := range pp.includes {
+		if absPath(frame.lxr.file) == abs {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pragma handles a %pragma directive line. The once pragma stops the current
+// file from being included again.
+func (pp *Preprocessor) pragma(line []Token) *Error {
+	name := line[1]
+	if name.ID() != Identifier {
+		return tokenError(name, "expected pragma name, found "+describe(name))
+	}
+
+	switch strings.ToLower(name.Raw()) {
+	case "once":
+		if err := expectEnd(line[2]); err != nil {
+			return err
+		}
+
+		if pp.lxr.file != "" {
+			pp.once[absPath(pp.lxr.file)] = true
+		}
+
+		return nil
+	}
+
+	return tokenError(name, fmt.Sprintf("unknown pragma '%s'", name.Raw()))
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// absPath returns the absolute path of the file, which identifies it no matter
+// how it was included. Files without a name have no path.
+func absPath(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+
+	return abs
+}
@@ -35,29 +35,183 @@ const (
 	Comma                      // represents the character ','
 	Colon                      // represents the character ':'
 	Newline                    // represents a newline
+	LBracket                   // represents the character '['
+	RBracket                   // represents the character ']'
+	Operator                   // represents an arithmetic, bitwise, comparison or logical operator
+	Directive                  // represents a directive keyword
+	LParen                     // represents the character '('
+	RParen                     // represents the character ')'
+	Size                       // represents an operand size keyword
+	Here                       // represents the current position, '$', or its section's start, '$$'
+	MacroParam                 // represents a macro parameter, like '%1', or the argument count, '%0'
+	MacroLabel                 // represents a macro-local label, like '%%loop'
 
 	Identifier // represents an identifier/name
 	Hex        // represents a hexadecimal number
 	Octal      // represents an octal number
+	Binary     // represents a binary number
 	Decimal    // represents a decimal number
+	String     // represents a quoted string, or character, literal
 )
 
+// A DirectiveID identifies a directive. It's saved in the special ID of
+// [Directive] tokens.
+type DirectiveID uint
+
+const (
+	_            DirectiveID = iota << 5
+	GlobalDir                // represents the global directive
+	ExternDir                // represents the extern directive
+	StaticDir                // represents the static directive
+	DbDir                    // represents the db (define byte) directive
+	DwDir                    // represents the dw (define word) directive
+	DdDir                    // represents the dd (define doubleword) directive
+	DqDir                    // represents the dq (define quadword) directive
+	DtDir                    // represents the dt (define ten bytes) directive
+	DoDir                    // represents the do (define octoword) directive
+	ResbDir                  // represents the resb (reserve bytes) directive
+	ReswDir                  // represents the resw (reserve words) directive
+	ResdDir                  // represents the resd (reserve doublewords) directive
+	ResqDir                  // represents the resq (reserve quadwords) directive
+	EquDir                   // represents the equ (constant definition) directive
+	AssignDir                // represents the %assign (redefinable constant) directive
+	TimesDir                 // represents the times (repetition) prefix
+	DefineDir                // represents the %define (single-line macro) directive
+	IdefineDir               // represents the %idefine (case-insensitive macro) directive
+	UndefDir                 // represents the %undef (macro removal) directive
+	MacroDir                 // represents the %macro (multi-line macro) directive
+	EndmacroDir              // represents the %endmacro (end of multi-line macro) directive
+	IfDir                    // represents the %if (conditional assembly) directive
+	IfdefDir                 // represents the %ifdef (macro defined condition) directive
+	IfndefDir                // represents the %ifndef (macro not defined condition) directive
+	IfidnDir                 // represents the %ifidn (identical text condition) directive
+	IfidniDir                // represents the %ifidni (case-insensitive identical text condition) directive
+	IfmacroDir               // represents the %ifmacro (multi-line macro defined condition) directive
+	ElifDir                  // represents the %elif directive
+	ElifdefDir               // represents the %elifdef directive
+	ElifndefDir              // represents the %elifndef directive
+	ElifidnDir               // represents the %elifidn directive
+	ElifidniDir              // represents the %elifidni directive
+	ElifmacroDir             // represents the %elifmacro directive
+	ElseDir                  // represents the %else directive
+	EndifDir                 // represents the %endif (end of conditional block) directive
+	IncludeDir               // represents the %include (source file inclusion) directive
+	PragmaDir                // represents the %pragma (preprocessor option) directive
+	IncbinDir                // represents the incbin (binary file inclusion) directive
+	RepDir                   // represents the %rep (repeated lines) directive
+	EndrepDir                // represents the %endrep (end of repeated lines) directive
+	ExitrepDir               // represents the %exitrep (early end of repetition) directive
+)
+
+var directiveSearchMap = map[string]DirectiveID{
+	"global":     GlobalDir,
+	"extern":     ExternDir,
+	"static":     StaticDir,
+	"db":         DbDir,
+	"dw":         DwDir,
+	"dd":         DdDir,
+	"dq":         DqDir,
+	"dt":         DtDir,
+	"do":         DoDir,
+	"resb":       ResbDir,
+	"resw":       ReswDir,
+	"resd":       ResdDir,
+	"resq":       ResqDir,
+	"equ":        EquDir,
+	"%assign":    AssignDir,
+	"times":      TimesDir,
+	"%define":    DefineDir,
+	"%idefine":   IdefineDir,
+	"%undef":     UndefDir,
+	"%macro":     MacroDir,
+	"%endmacro":  EndmacroDir,
+	"%if":        IfDir,
+	"%ifdef":     IfdefDir,
+	"%ifndef":    IfndefDir,
+	"%ifidn":     IfidnDir,
+	"%ifidni":    IfidniDir,
+	"%ifmacro":   IfmacroDir,
+	"%elif":      ElifDir,
+	"%elifdef":   ElifdefDir,
+	"%elifndef":  ElifndefDir,
+	"%elifidn":   ElifidnDir,
+	"%elifidni":  ElifidniDir,
+	"%elifmacro": ElifmacroDir,
+	"%else":      ElseDir,
+	"%endif":     EndifDir,
+	"%include":   IncludeDir,
+	"%pragma":    PragmaDir,
+	"incbin":     IncbinDir,
+	"%rep":       RepDir,
+	"%endrep":    EndrepDir,
+	"%exitrep":   ExitrepDir,
+}
+
+// A SizeID identifies an operand size keyword. It's saved in the special ID of
+// [Size] tokens.
+type SizeID uint
+
+const (
+	_         SizeID = iota << 5
+	ByteSize         // represents the byte (8-bit) size keyword
+	WordSize         // represents the word (16-bit) size keyword
+	DwordSize        // represents the dword (32-bit) size keyword
+	QwordSize        // represents the qword (64-bit) size keyword
+	TwordSize        // represents the tword (80-bit) size keyword
+	OwordSize        // represents the oword (128-bit) size keyword
+	YwordSize        // represents the yword (256-bit) size keyword
+	ZwordSize        // represents the zword (512-bit) size keyword
+)
+
+var sizeSearchMap = map[string]SizeID{
+	"byte":  ByteSize,
+	"word":  WordSize,
+	"dword": DwordSize,
+	"qword": QwordSize,
+	"tword": TwordSize,
+	"oword": OwordSize,
+	"yword": YwordSize,
+	"zword": ZwordSize,
+}
+
+var sizeBits = map[SizeID]uint{
+	ByteSize:  8,
+	WordSize:  16,
+	DwordSize: 32,
+	QwordSize: 64,
+	TwordSize: 80,
+	OwordSize: 128,
+	YwordSize: 256,
+	ZwordSize: 512,
+}
+
+// Bits returns the size, in bits, given by the size keyword.
+func (s SizeID) Bits() uint {
+	return sizeBits[s]
+}
+
 // Token represents the output of the [Lexer], containing information
 // about the lexed input.
 type Token struct {
-	pos Position
+	pos  Position
+	file string // the name of the source file the token is in, if it's known
 	// id contains the above `TokenID` constants in the first 5 bits,
-	// and in the cases of `Instruction` and `Register` the rest contains the
-	// instruction/register identifiers.
+	// and in the cases of `Instruction`, `Register`, `Directive` and `Size` the
+	// rest contains the instruction/register/directive/size identifiers.
 	id TokenID
 	// raw contains the string lexed by the lexer.
 	raw string
+	// end is the end of the token's span, if it isn't given by its raw string,
+	// like for the tokens expanded from a macro, which span its invocation.
+	end Position
+	// exp is the expansion of the multi-line macro the token is part of.
+	exp *expansion
 }
 
 // NewToken creates a new token based on the given parameters.
 // Possibly will be removed as it seems quite unnecessary.
 func NewToken(pos Position, id TokenID, raw string) Token {
-	return Token{pos, id, raw}
+	return Token{pos: pos, id: id, raw: raw}
 }
 
 // Pos returns the [Position] saved in the token.
@@ -65,14 +219,20 @@ func (t *Token) Pos() Position {
 	return t.pos
 }
 
+// File returns the name of the source file the token is in, or an empty
+// string if it isn't known.
+func (t *Token) File() string {
+	return t.file
+}
+
 // ID returns the [TokenID] of the token.
 func (t *Token) ID() TokenID {
 	return t.id & 0x1f
 }
 
 // SpecID returns a "special" ID of the token. Should only be used for [Token]'s of
-// type [Instruction] or [Register], otherwise it will, and should, always
-// return zero.
+// type [Instruction], [Register], [Directive] or [Size], otherwise it will, and
+// should, always return zero.
 func (t *Token) SpecID() uint {
 	return (uint(t.id) >> 5) << 5
 }
@@ -82,27 +242,73 @@ func (t *Token) Raw() string {
 	return t.raw
 }
 
+// A CommentStyle represents the styles of line comments a [Lexer] accepts,
+// besides the ones starting with ';', which are always accepted. The styles
+// can be combined, like HashComments | SlashComments.
+type CommentStyle uint
+
+const (
+	HashComments  CommentStyle = 1 << iota // represents comments starting with '#'
+	SlashComments                          // represents comments starting with '//'
+)
+
 // A Lexer is object which turns the source file into tokens, which are
 // used by the [Parser].
+//
+// Comments, including block comments between '/*' and '*/', are skipped like
+// whitespace. A backslash at the end of a line continues it on the next one.
 type Lexer struct {
-	rd  *bufio.Reader
-	pos Position
-	sb  strings.Builder
+	rd        *bufio.Reader
+	file      string
+	pos       Position
+	sb        strings.Builder
+	comments  CommentStyle
+	lineStart bool   // reports if no token was lexed yet on the current line
+	pending   *Token // the token to return next, lexed ahead of its time
 }
 
 // NewLexer create a new [Lexer] based on the [io.Reader] given to it.
 func NewLexer(rd io.Reader) *Lexer {
 	return &Lexer{
-		rd:  bufio.NewReader(rd),
-		pos: Position{Line: 1, Col: 0},
-		sb:  strings.Builder{},
+		rd:        bufio.NewReader(rd),
+		pos:       Position{Line: 1, Col: 0},
+		sb:        strings.Builder{},
+		lineStart: true,
 	}
 }
 
+// NewLexerFile creates a new [Lexer] based on the [io.Reader] given to it,
+// whose errors are reported as being in the named file.
+func NewLexerFile(name string, rd io.Reader) *Lexer {
+	lxr := NewLexer(rd)
+	lxr.file = name
+
+	return lxr
+}
+
+// SetCommentStyle sets the styles of line comments accepted by the lexer,
+// besides the ones starting with ';'.
+func (l *Lexer) SetCommentStyle(style CommentStyle) {
+	l.comments = style
+}
+
 // Next lexes and returns the next token in the source file. If the file
 // has been fully lexed, Next will always return a token with the [EOF]
 // [TokenID].
 func (l *Lexer) Next() Token {
+	tok := l.next()
+	tok.file = l.file
+	l.lineStart = tok.ID() == Newline
+
+	return tok
+}
+
+func (l *Lexer) next() Token {
+	if tok := l.pending; tok != nil {
+		l.pending = nil
+		return *tok
+	}
+
 	for {
 		pos := l.pos
 
@@ -116,8 +322,76 @@ func (l *Lexer) Next() Token {
 			return Token{pos: pos, id: Comma, raw: ","}
 		case ':':
 			return Token{pos: pos, id: Colon, raw: ":"}
-		case '0':
-			return l.lexZero()
+		case '[':
+			return Token{pos: pos, id: LBracket, raw: "["}
+		case ']':
+			return Token{pos: pos, id: RBracket, raw: "]"}
+		case '(':
+			return Token{pos: pos, id: LParen, raw: "("}
+		case ')':
+			return Token{pos: pos, id: RParen, raw: ")"}
+		case '%':
+			if next, isEOF := l.read(); !isEOF {
+				if next == '%' {
+					return l.lexMacroLabel(pos)
+				}
+
+				l.unread()
+
+				if unicode.IsDigit(next) {
+					return l.lexMacroParam(pos)
+				} else if unicode.IsLetter(next) {
+					return l.lexPreprocDirective(pos)
+				}
+			}
+
+			return Token{pos: pos, id: Operator, raw: "%"}
+		case ';':
+			l.skipLine()
+			continue
+		case '#':
+			if l.comments&HashComments != 0 {
+				l.skipLine()
+				continue
+			}
+
+			return Token{pos: pos, id: Illegal, raw: "#"}
+		case '\\':
+			if l.skipContinuation() {
+				continue
+			}
+
+			return Token{pos: pos, id: Illegal, raw: "\\"}
+		case '/':
+			next, isEOF := l.read()
+			if !isEOF && next == '/' && l.comments&SlashComments != 0 {
+				l.skipLine()
+				continue
+			} else if !isEOF && next == '*' {
+				if !l.skipBlockComment() {
+					return Token{pos: pos, id: Illegal, raw: "unterminated block comment"}
+				}
+
+				continue
+			} else if !isEOF {
+				l.unread()
+			}
+
+			return Token{pos: pos, id: Operator, raw: "/"}
+		case '+', '-', '*', '~':
+			return Token{pos: pos, id: Operator, raw: string(r)}
+		case '&', '|', '^', '<', '>', '=', '!':
+			return l.lexOperator(pos, r)
+		case '$':
+			if next, isEOF := l.read(); !isEOF && next == '$' {
+				return Token{pos: pos, id: Here, raw: "$$"}
+			} else if !isEOF {
+				l.unread()
+			}
+
+			return Token{pos: pos, id: Here, raw: "$"}
+		case '\'', '"', '`':
+			return l.lexString(pos, r)
 		case '\n':
 			l.pos.Line++
 			l.pos.Col = 0
@@ -129,7 +403,7 @@ func (l *Lexer) Next() Token {
 			} else if unicode.IsDigit(r) {
 				l.unread()
 
-				return l.lexDecimal()
+				return l.lexNumber()
 			} else if unicode.IsLetter(r) || r == '_' || r == '.' {
 				l.unread()
 
@@ -141,6 +415,66 @@ func (l *Lexer) Next() Token {
 	}
 }
 
+// skipLine skips the rest of the line, up to its newline.
+func (l *Lexer) skipLine() {
+	for {
+		r, isEOF := l.read()
+		if isEOF {
+			return
+		}
+
+		if r == '\n' {
+			l.unread()
+			return
+		}
+	}
+}
+
+// skipBlockComment skips the rest of a block comment, which can span multiple
+// lines. It reports if the comment is terminated by '*/'.
+func (l *Lexer) skipBlockComment() bool {
+	prev := rune(0)
+
+	for {
+		r, isEOF := l.read()
+		if isEOF {
+			return false
+		}
+
+		switch {
+		case r == '\n':
+			l.pos.Line++
+			l.pos.Col = 0
+		case prev == '*' && r == '/':
+			return true
+		}
+
+		prev = r
+	}
+}
+
+// skipContinuation skips the newline after a backslash, which may only be
+// followed by whitespace. It reports if the line is continued.
+func (l *Lexer) skipContinuation() bool {
+	for {
+		r, isEOF := l.read()
+		if isEOF {
+			return false
+		}
+
+		switch {
+		case r == '\n':
+			l.pos.Line++
+			l.pos.Col = 0
+
+			return true
+		case !unicode.IsSpace(r):
+			l.unread()
+			return false
+		}
+	}
+}
+
 func (l *Lexer) unread() {
 	if err := l.rd.UnreadRune(); err != nil {
 		panic(err)
@@ -150,8 +484,6 @@ func (l *Lexer) unread() {
 }
 
 func (l *Lexer) read() (rune, bool) {
-	l.pos.Col++
-
 	r, _, err := l.rd.ReadRune()
 	if err != nil {
 		if err == io.EOF {
@@ -161,6 +493,8 @@ func (l *Lexer) read() (rune, bool) {
 		panic(err)
 	}
 
+	l.pos.Col++
+
 	return r, false
 }
 
@@ -178,113 +512,260 @@ func (l *Lexer) popStr() string {
 	return str
 }
 
-func (l *Lexer) lexZero() Token {
-	r, isEOF := l.read()
-	if isEOF {
-		return Token{pos: Position{Line: l.pos.Line, Col: l.pos.Col - 2}, id: Decimal, raw: "0"}
-	}
+// lexNumber lexes a number, whose base is given by a prefix, like "0x", or a
+// suffix, like "h". Its digits can be separated by underscores. The raw string
+// of a prefixed number doesn't include its prefix, while the raw string of a
+// suffixed number includes its suffix.
+func (l *Lexer) lexNumber() Token {
+	pos := l.pos
 
-	switch r {
-	case 'x', 'X':
-		return l.lexHex()
-	case 'o', 'O':
-		return l.lexOctal()
-	default:
-		if unicode.IsDigit(r) {
-			l.unread()
-			tok := l.lexDecimal()
-			tok.raw = string('0') + tok.raw
-			tok.pos.Col--
+	for {
+		r, isEOF := l.read()
+		if isEOF {
+			break
+		}
 
-			return tok
-		} else {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
 			l.unread()
+			break
+		}
+
+		l.writeStr(r)
+	}
 
-			return Token{pos: Position{Line: l.pos.Line, Col: l.pos.Col - 1}, id: Decimal, raw: "0"}
+	raw := l.popStr()
+	if id, ok := barePrefixes[strings.ToLower(raw)]; ok {
+		return Token{
+			pos: pos,
+			id:  Illegal,
+			raw: numberNames[id] + " prefix without logical continuation",
 		}
 	}
+
+	id, unprefixed, ok := splitNumber(raw)
+	if !ok {
+		return Token{pos: pos, id: Illegal, raw: "invalid number '" + raw + "'"}
+	}
+
+	return Token{pos: pos, id: id, raw: unprefixed}
 }
 
-func (l *Lexer) lexHex() Token {
-	pos := l.pos
-	pos.Col -= 2
+// numberBases maps the number token IDs to their bases.
+var numberBases = map[TokenID]int{
+	Hex:     16,
+	Octal:   8,
+	Binary:  2,
+	Decimal: 10,
+}
 
-	for {
-		r, isEOF := l.read()
-		if isEOF {
-			return Token{pos: pos, id: Illegal, raw: "hex prefix without logical continuation"}
+// numberNames maps the number token IDs to the names of their bases.
+var numberNames = map[TokenID]string{
+	Hex:     "hex",
+	Octal:   "octal",
+	Binary:  "binary",
+	Decimal: "decimal",
+}
+
+// numberForms contains the prefixes and suffixes which give the base of a
+// number, in the order they're tried.
+var numberForms = []struct {
+	id     TokenID
+	prefix string
+	suffix string
+}{
+	{Hex, "0x", ""},
+	{Hex, "", "h"},
+	{Octal, "0o", ""},
+	{Octal, "0q", ""},
+	{Octal, "", "o"},
+	{Octal, "", "q"},
+	{Binary, "0b", ""},
+	{Binary, "0y", ""},
+	{Binary, "", "b"},
+	{Binary, "", "y"},
+	{Decimal, "0d", ""},
+	{Decimal, "", "d"},
+	{Decimal, "", ""},
+}
+
+// barePrefixes contains the prefixes which aren't numbers on their own. The
+// rest of them are read as a zero with a suffix, like "0b" and "0d".
+var barePrefixes = map[string]TokenID{
+	"0x": Hex,
+	"0o": Octal,
+}
+
+// splitNumber returns the token ID of a number literal, and the literal without
+// the prefix giving its base, if it has one.
+func splitNumber(raw string) (TokenID, string, bool) {
+	lower := strings.ToLower(raw)
+
+	for _, form := range numberForms {
+		if len(lower) < len(form.prefix)+len(form.suffix) ||
+			!strings.HasPrefix(lower, form.prefix) ||
+			!strings.HasSuffix(lower, form.suffix) {
+			continue
 		}
 
-		switch r {
-		case 'A', 'B', 'C', 'D', 'E', 'F', 'a', 'b', 'c', 'd', 'e', 'f':
-			l.writeStr(r)
-		default:
-			if unicode.IsDigit(r) {
-				l.writeStr(r)
-			} else {
-				l.unread()
+		digits := lower[len(form.prefix) : len(lower)-len(form.suffix)]
+		digits = strings.ReplaceAll(digits, "_", "")
+		if isDigits(digits, numberBases[form.id]) {
+			return form.id, raw[len(form.prefix):], true
+		}
+	}
 
-				raw := l.popStr()
-				if raw == "" {
-					return Token{pos: pos, id: Illegal, raw: "hex prefix without logical continuation"}
-				}
+	return Illegal, "", false
+}
 
-				return Token{pos: pos, id: Hex, raw: raw}
-			}
+// numberDigits returns the digits of a number token's raw string, without the
+// suffix giving its base, and without separating underscores.
+func numberDigits(id TokenID, raw string) string {
+	digits := strings.ToLower(raw)
+
+	for _, form := range numberForms {
+		if form.id == id && form.suffix != "" && strings.HasSuffix(digits, form.suffix) {
+			digits = digits[:len(digits)-len(form.suffix)]
+			break
 		}
 	}
+
+	return strings.ReplaceAll(digits, "_", "")
 }
 
-func (l *Lexer) lexOctal() Token {
-	pos := l.pos
-	pos.Col -= 2
+// isDigits reports if the string is made up of lowercase digits of the base.
+func isDigits(str string, base int) bool {
+	if str == "" {
+		return false
+	}
+
+	for _, r := range str {
+		if !strings.ContainsRune("0123456789abcdef"[:base], r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lexString lexes a string literal ending with the given quote. The raw string
+// of the token includes its quotes, and its escape sequences are left as is.
+// Like in NASM, escape sequences are only recognized in backquoted strings.
+func (l *Lexer) lexString(pos Position, quote rune) Token {
+	l.writeStr(quote)
 
 	for {
 		r, isEOF := l.read()
-		if isEOF {
-			return Token{
-				pos: pos,
-				id:  Illegal,
-				raw: "octal prefix without logical continuation",
+		if isEOF || r == '\n' {
+			if !isEOF {
+				l.unread()
 			}
+
+			l.popStr()
+			return Token{pos: pos, id: Illegal, raw: "unterminated string"}
 		}
 
-		switch r {
-		case '0', '1', '2', '3', '4', '5', '6', '7':
-			l.writeStr(r)
-		default:
-			l.unread()
+		l.writeStr(r)
 
-			raw := l.popStr()
-			if raw == "" {
-				return Token{
-					pos: pos,
-					id:  Illegal,
-					raw: "octal prefix without logical continuation",
-				}
+		switch {
+		case r == quote:
+			return Token{pos: pos, id: String, raw: l.popStr()}
+		case r == '\\' && quote == '`':
+			// The escaped character is kept, so it can't end the string.
+			r, isEOF := l.read()
+			if isEOF {
+				continue
+			} else if r == '\n' {
+				l.unread()
+				continue
 			}
 
-			return Token{pos: pos, id: Octal, raw: raw}
+			l.writeStr(r)
 		}
 	}
 }
 
-func (l *Lexer) lexDecimal() Token {
-	pos := l.pos
+// lexPreprocDirective lexes a directive starting with '%', which was already
+// read. Unknown directives are only reported at the start of a line, since
+// elsewhere the '%' is taken as the modulo operator, followed by a word.
+func (l *Lexer) lexPreprocDirective(pos Position) Token {
+	word := l.lexIdentifier()
+	raw := "%" + word.raw
+
+	tok := Token{pos: pos, id: identTokenID(raw), raw: raw}
+	switch {
+	case tok.ID() == Directive:
+		return tok
+	case l.lineStart:
+		return Token{pos: pos, id: Illegal, raw: "unknown directive " + raw}
+	}
+
+	l.pending = &word
+
+	return Token{pos: pos, id: Operator, raw: "%"}
+}
+
+// lexMacroParam lexes a macro parameter, after its '%'.
+func (l *Lexer) lexMacroParam(pos Position) Token {
+	l.writeStr('%')
 
 	for {
 		r, isEOF := l.read()
 		if isEOF {
-			return Token{pos: pos, id: Decimal, raw: l.popStr()}
+			break
 		}
 
-		if unicode.IsDigit(r) {
-			l.writeStr(r)
-		} else {
+		if !unicode.IsDigit(r) {
 			l.unread()
-			return Token{pos: pos, id: Decimal, raw: l.popStr()}
+			break
+		}
+
+		l.writeStr(r)
+	}
+
+	return Token{pos: pos, id: MacroParam, raw: l.popStr()}
+}
+
+// twoCharOps contains the operators made of two characters, by their first one.
+var twoCharOps = map[rune][]string{
+	'&': {"&&"},
+	'|': {"||"},
+	'^': {"^^"},
+	'<': {"<<", "<=", "<>"},
+	'>': {">>", ">="},
+	'=': {"=="},
+	'!': {"!="},
+}
+
+// lexOperator lexes an operator, which can be made of two characters, starting
+// with the given one, which was already read.
+func (l *Lexer) lexOperator(pos Position, r rune) Token {
+	next, isEOF := l.read()
+	if isEOF {
+		return Token{pos: pos, id: Operator, raw: string(r)}
+	}
+
+	for _, op := range twoCharOps[r] {
+		if []rune(op)[1] == next {
+			return Token{pos: pos, id: Operator, raw: op}
 		}
 	}
+
+	l.unread()
+
+	return Token{pos: pos, id: Operator, raw: string(r)}
+}
+
+// lexMacroLabel lexes a macro-local label, after its '%%'.
+func (l *Lexer) lexMacroLabel(pos Position) Token {
+	l.writeStr('%')
+	l.writeStr('%')
+
+	tok := l.lexIdentifier()
+	if tok.raw == "%%" {
+		return Token{pos: pos, id: Illegal, raw: "expected label name after '%%'"}
+	}
+
+	return Token{pos: pos, id: MacroLabel, raw: tok.raw}
 }
 
 func (l *Lexer) lexIdentifier() Token {
@@ -315,8 +796,12 @@ func identTokenID(id string) TokenID {
 	default:
 		if instr := x86.MnemonicSearchMap[ident]; instr != 0 {
 			return Instruction | TokenID(instr)
+		} else if dir := directiveSearchMap[ident]; dir != 0 {
+			return Directive | TokenID(dir)
 		} else if reg := x86.RegisterSearchMap[ident]; reg != 0 {
 			return Register | TokenID(reg)
+		} else if size := sizeSearchMap[ident]; size != 0 {
+			return Size | TokenID(size)
 		} else {
 			return Identifier
 		}
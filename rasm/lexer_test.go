@@ -79,35 +79,165 @@ func TestLexer_Next(t *testing.T) {
 			want: rasm.NewToken(pos0, rasm.Decimal, "00000"),
 		},
 		{
-			name: "Should lex decimal numbers before letters separately",
+			name: "Should not lex decimal numbers followed by letters",
 			rd:   strings.NewReader("512hello"),
-			want: rasm.NewToken(pos0, rasm.Decimal, "512"),
+			want: rasm.NewToken(pos0, rasm.Illegal, "invalid number '512hello'"),
 		},
 		{
 			name: "Should lex hexadecimal numbers (x)",
-			rd:   strings.NewReader("0x0123456789AbCdEfGhIjKl"),
+			rd:   strings.NewReader("0x0123456789AbCdEf"),
 			want: rasm.NewToken(pos0, rasm.Hex, "0123456789AbCdEf"),
 		},
 		{
 			name: "Should lex hexadecimal numbers (X)",
-			rd:   strings.NewReader("0X0123456789AbCdEfGhIjKl"),
+			rd:   strings.NewReader("0X0123456789AbCdEf"),
 			want: rasm.NewToken(pos0, rasm.Hex, "0123456789AbCdEf"),
 		},
 		{
 			name: "Should lex octal numbers (o)",
-			rd:   strings.NewReader("0o0123456789"),
+			rd:   strings.NewReader("0o01234567"),
 			want: rasm.NewToken(pos0, rasm.Octal, "01234567"),
 		},
 		{
 			name: "Should lex octal numbers (O)",
-			rd:   strings.NewReader("0O0123456789"),
+			rd:   strings.NewReader("0O01234567"),
 			want: rasm.NewToken(pos0, rasm.Octal, "01234567"),
 		},
+		{
+			name: "Should not lex octal numbers with invalid digits",
+			rd:   strings.NewReader("0o0123456789"),
+			want: rasm.NewToken(pos0, rasm.Illegal, "invalid number '0o0123456789'"),
+		},
+		{
+			name: "Should lex binary numbers",
+			rd:   strings.NewReader("0b1010"),
+			want: rasm.NewToken(pos0, rasm.Binary, "1010"),
+		},
+		{
+			name: "Should lex hexadecimal numbers (suffix)",
+			rd:   strings.NewReader("0FFh"),
+			want: rasm.NewToken(pos0, rasm.Hex, "0FFh"),
+		},
+		{
+			name: "Should lex hexadecimal numbers ending with 'b' (suffix)",
+			rd:   strings.NewReader("0bh"),
+			want: rasm.NewToken(pos0, rasm.Hex, "0bh"),
+		},
+		{
+			name: "Should lex octal numbers (suffix)",
+			rd:   strings.NewReader("777q"),
+			want: rasm.NewToken(pos0, rasm.Octal, "777q"),
+		},
+		{
+			name: "Should lex binary numbers (suffix)",
+			rd:   strings.NewReader("1010b"),
+			want: rasm.NewToken(pos0, rasm.Binary, "1010b"),
+		},
+		{
+			name: "Should lex numbers with digit separators",
+			rd:   strings.NewReader("1_000_000"),
+			want: rasm.NewToken(pos0, rasm.Decimal, "1_000_000"),
+		},
+		{
+			name: "Should lex hexadecimal numbers at EOF",
+			rd:   strings.NewReader("0xff"),
+			want: rasm.NewToken(pos0, rasm.Hex, "ff"),
+		},
 		{
 			name: "Should lex section keyword",
 			rd:   strings.NewReader("sEcTiOn"),
 			want: rasm.NewToken(pos0, rasm.Section, "sEcTiOn"),
 		},
+		{
+			name: "Should lex size keyword",
+			rd:   strings.NewReader("DWord"),
+			want: rasm.NewToken(pos0, rasm.TokenID(rasm.DwordSize)|rasm.Size, "DWord"),
+		},
+		{
+			name: "Should skip line comment",
+			rd:   strings.NewReader("; mov eax, 1\n"),
+			want: rasm.NewToken(rasm.Position{1, 12}, rasm.Newline, "\\n"),
+		},
+		{
+			name: "Should skip block comment across lines",
+			rd:   strings.NewReader("/* a\n b */ mov"),
+			want: rasm.NewToken(rasm.Position{2, 6}, rasm.Instruction|rasm.TokenID(x86.MOV), "mov"),
+		},
+		{
+			name: "Should not lex unterminated block comment",
+			rd:   strings.NewReader("/* a *"),
+			want: rasm.NewToken(pos0, rasm.Illegal, "unterminated block comment"),
+		},
+		{
+			name: "Should lex division operator",
+			rd:   strings.NewReader("/ 2"),
+			want: rasm.NewToken(pos0, rasm.Operator, "/"),
+		},
+		{
+			name: "Should not lex hash without hash comments",
+			rd:   strings.NewReader("# mov"),
+			want: rasm.NewToken(pos0, rasm.Illegal, "#"),
+		},
+		{
+			name: "Should continue line after backslash",
+			rd:   strings.NewReader("\\  \n  mov"),
+			want: rasm.NewToken(rasm.Position{2, 2}, rasm.Instruction|rasm.TokenID(x86.MOV), "mov"),
+		},
+		{
+			name: "Should not lex backslash inside line",
+			rd:   strings.NewReader("\\ mov"),
+			want: rasm.NewToken(pos0, rasm.Illegal, "\\"),
+		},
+		{
+			name: "Should lex macro parameter",
+			rd:   strings.NewReader("%12,"),
+			want: rasm.NewToken(pos0, rasm.MacroParam, "%12"),
+		},
+		{
+			name: "Should lex macro-local label",
+			rd:   strings.NewReader("%%.skip:"),
+			want: rasm.NewToken(pos0, rasm.MacroLabel, "%%.skip"),
+		},
+		{
+			name: "Should not lex macro-local label without name",
+			rd:   strings.NewReader("%% 1"),
+			want: rasm.NewToken(pos0, rasm.Illegal, "expected label name after '%%'"),
+		},
+		{
+			name: "Should lex modulo operator",
+			rd:   strings.NewReader("% 2"),
+			want: rasm.NewToken(pos0, rasm.Operator, "%"),
+		},
+		{
+			name: "Should lex current position",
+			rd:   strings.NewReader("$-"),
+			want: rasm.NewToken(pos0, rasm.Here, "$"),
+		},
+		{
+			name: "Should lex section start position",
+			rd:   strings.NewReader("$$"),
+			want: rasm.NewToken(pos0, rasm.Here, "$$"),
+		},
+		{
+			name: "Should lex directive keyword",
+			rd:   strings.NewReader("Global"),
+			want: rasm.NewToken(pos0, rasm.TokenID(rasm.GlobalDir)|rasm.Directive, "Global"),
+		},
+		{
+			name: "Should lex preprocessor directive keyword",
+			rd:   strings.NewReader("%Assign x 1"),
+			want: rasm.NewToken(pos0, rasm.TokenID(rasm.AssignDir)|rasm.Directive, "%Assign"),
+		},
+		{
+			name: "Should lex '%' operator",
+			rd:   strings.NewReader("% 2"),
+			want: rasm.NewToken(pos0, rasm.Operator, "%"),
+		},
+		{
+			name: "Should not lex unknown preprocessor directive",
+			rd:   strings.NewReader("%nothing"),
+			want: rasm.NewToken(pos0, rasm.Illegal, "unknown directive %nothing"),
+		},
 		{
 			name: "Should lex ','",
 			rd:   strings.NewReader(","),
@@ -118,11 +248,126 @@ func TestLexer_Next(t *testing.T) {
 			rd:   strings.NewReader(":"),
 			want: rasm.NewToken(pos0, rasm.Colon, ":"),
 		},
+		{
+			name: "Should lex '['",
+			rd:   strings.NewReader("["),
+			want: rasm.NewToken(pos0, rasm.LBracket, "["),
+		},
+		{
+			name: "Should lex ']'",
+			rd:   strings.NewReader("]"),
+			want: rasm.NewToken(pos0, rasm.RBracket, "]"),
+		},
+		{
+			name: "Should lex '*' operator",
+			rd:   strings.NewReader("*"),
+			want: rasm.NewToken(pos0, rasm.Operator, "*"),
+		},
+		{
+			name: "Should lex '-' operator",
+			rd:   strings.NewReader("-8"),
+			want: rasm.NewToken(pos0, rasm.Operator, "-"),
+		},
+		{
+			name: "Should lex '('",
+			rd:   strings.NewReader("(1"),
+			want: rasm.NewToken(pos0, rasm.LParen, "("),
+		},
+		{
+			name: "Should lex ')'",
+			rd:   strings.NewReader(")"),
+			want: rasm.NewToken(pos0, rasm.RParen, ")"),
+		},
+		{
+			name: "Should lex '<<' operator",
+			rd:   strings.NewReader("<<2"),
+			want: rasm.NewToken(pos0, rasm.Operator, "<<"),
+		},
+		{
+			name: "Should lex '>>' operator",
+			rd:   strings.NewReader(">>"),
+			want: rasm.NewToken(pos0, rasm.Operator, ">>"),
+		},
+		{
+			name: "Should lex '~' operator",
+			rd:   strings.NewReader("~"),
+			want: rasm.NewToken(pos0, rasm.Operator, "~"),
+		},
+		{
+			name: "Should lex '<' operator",
+			rd:   strings.NewReader("< 2"),
+			want: rasm.NewToken(pos0, rasm.Operator, "<"),
+		},
+		{
+			name: "Should lex '<=' operator",
+			rd:   strings.NewReader("<= 2"),
+			want: rasm.NewToken(pos0, rasm.Operator, "<="),
+		},
+		{
+			name: "Should lex '<>' operator",
+			rd:   strings.NewReader("<> 2"),
+			want: rasm.NewToken(pos0, rasm.Operator, "<>"),
+		},
+		{
+			name: "Should lex '==' operator",
+			rd:   strings.NewReader("== 2"),
+			want: rasm.NewToken(pos0, rasm.Operator, "=="),
+		},
+		{
+			name: "Should lex '!=' operator",
+			rd:   strings.NewReader("!= 2"),
+			want: rasm.NewToken(pos0, rasm.Operator, "!="),
+		},
+		{
+			name: "Should lex '!' operator",
+			rd:   strings.NewReader("!x"),
+			want: rasm.NewToken(pos0, rasm.Operator, "!"),
+		},
+		{
+			name: "Should lex '&&' operator",
+			rd:   strings.NewReader("&& 1"),
+			want: rasm.NewToken(pos0, rasm.Operator, "&&"),
+		},
+		{
+			name: "Should lex '^' operator before another operator",
+			rd:   strings.NewReader("^~1"),
+			want: rasm.NewToken(pos0, rasm.Operator, "^"),
+		},
 		{
 			name: "Should lex newline",
 			rd:   strings.NewReader("\n"),
 			want: rasm.NewToken(pos0, rasm.Newline, "\\n"),
 		},
+		{
+			name: "Should lex double quoted string",
+			rd:   strings.NewReader(`"say 'hi'", 0`),
+			want: rasm.NewToken(pos0, rasm.String, `"say 'hi'"`),
+		},
+		{
+			name: "Should lex double quoted string without escapes",
+			rd:   strings.NewReader(`"C:\dir\", 0`),
+			want: rasm.NewToken(pos0, rasm.String, `"C:\dir\"`),
+		},
+		{
+			name: "Should lex single quoted string without escapes",
+			rd:   strings.NewReader(`'\'`),
+			want: rasm.NewToken(pos0, rasm.String, `'\'`),
+		},
+		{
+			name: "Should lex backquoted string",
+			rd:   strings.NewReader("`\\x41`"),
+			want: rasm.NewToken(pos0, rasm.String, "`\\x41`"),
+		},
+		{
+			name: "Should lex backquoted string with escaped quotes",
+			rd:   strings.NewReader("`say \\`hi\\``, 0"),
+			want: rasm.NewToken(pos0, rasm.String, "`say \\`hi\\``"),
+		},
+		{
+			name: "Should not lex unterminated string",
+			rd:   strings.NewReader("\"abc\n\""),
+			want: rasm.NewToken(pos0, rasm.Illegal, "unterminated string"),
+		},
 		{
 			name: "Should not lex just the hex prefix (EOF)",
 			rd:   strings.NewReader("0x"),
@@ -143,6 +388,16 @@ func TestLexer_Next(t *testing.T) {
 			rd:   strings.NewReader("0o "),
 			want: rasm.NewToken(pos0, rasm.Illegal, "octal prefix without logical continuation"),
 		},
+		{
+			name: "Should lex just the binary prefix as a suffixed zero",
+			rd:   strings.NewReader("0b"),
+			want: rasm.NewToken(pos0, rasm.Binary, "0b"),
+		},
+		{
+			name: "Should lex just the decimal prefix as a suffixed zero",
+			rd:   strings.NewReader("0d "),
+			want: rasm.NewToken(pos0, rasm.Decimal, "0d"),
+		},
 		{
 			name: "Should not lex unknown symbols",
 			rd:   strings.NewReader("\\"),
@@ -202,3 +457,82 @@ func TestLexerPositioning(t *testing.T) {
 		}
 	}
 }
+
+func TestLexerEOFPositioning(t *testing.T) {
+	tests := []struct {
+		name string
+		rd   io.Reader
+		want rasm.Position
+	}{
+		{
+			name: "Should not move past the end after '%'",
+			rd:   strings.NewReader("mov eax, 7 %"),
+			want: rasm.Position{1, 12},
+		},
+		{
+			name: "Should not move past the end after '$'",
+			rd:   strings.NewReader("mov eax, $"),
+			want: rasm.Position{1, 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lxr := rasm.NewLexer(tt.rd)
+
+			tok := lxr.Next()
+			for tok.ID() != rasm.EOF {
+				tok = lxr.Next()
+			}
+
+			if tok.Pos() != tt.want {
+				t.Errorf("Next() = EOF at %v, want %v", tok.Pos(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLexerComments(t *testing.T) {
+	str := "mov ; one\n/* two\nthree */ # four\n// five\nadd \\\n  eax"
+	want := []rasm.Token{
+		rasm.NewToken(rasm.Position{1, 0}, rasm.Instruction|rasm.TokenID(x86.MOV), "mov"),
+		rasm.NewToken(rasm.Position{1, 9}, rasm.Newline, "\\n"),
+		rasm.NewToken(rasm.Position{3, 15}, rasm.Newline, "\\n"),
+		rasm.NewToken(rasm.Position{4, 7}, rasm.Newline, "\\n"),
+		rasm.NewToken(rasm.Position{5, 0}, rasm.Instruction|rasm.TokenID(x86.ADD), "add"),
+		rasm.NewToken(rasm.Position{6, 2}, rasm.Register|rasm.TokenID(x86.EAX), "eax"),
+	}
+
+	lxr := rasm.NewLexer(strings.NewReader(str))
+	lxr.SetCommentStyle(rasm.HashComments | rasm.SlashComments)
+
+	for i, tok := range want {
+		if got := lxr.Next(); !reflect.DeepEqual(got, tok) {
+			t.Fatalf("Next() #%d = %v, want %v", i, got, tok)
+		}
+	}
+}
+
+func TestLexerModulo(t *testing.T) {
+	str := "dd a %b, 7%eax\n  %nothing"
+	want := []rasm.Token{
+		rasm.NewToken(rasm.Position{1, 0}, rasm.Directive|rasm.TokenID(rasm.DdDir), "dd"),
+		rasm.NewToken(rasm.Position{1, 3}, rasm.Identifier, "a"),
+		rasm.NewToken(rasm.Position{1, 5}, rasm.Operator, "%"),
+		rasm.NewToken(rasm.Position{1, 6}, rasm.Identifier, "b"),
+		rasm.NewToken(rasm.Position{1, 7}, rasm.Comma, ","),
+		rasm.NewToken(rasm.Position{1, 9}, rasm.Decimal, "7"),
+		rasm.NewToken(rasm.Position{1, 10}, rasm.Operator, "%"),
+		rasm.NewToken(rasm.Position{1, 11}, rasm.Register|rasm.TokenID(x86.EAX), "eax"),
+		rasm.NewToken(rasm.Position{1, 14}, rasm.Newline, "\\n"),
+		rasm.NewToken(rasm.Position{2, 2}, rasm.Illegal, "unknown directive %nothing"),
+	}
+
+	lxr := rasm.NewLexer(strings.NewReader(str))
+
+	for i, tok := range want {
+		if got := lxr.Next(); !reflect.DeepEqual(got, tok) {
+			t.Fatalf("Next() #%d = %v, want %v", i, got, tok)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package rasm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxExpansionDepth is the maximum depth of nested multi-line macro
+// expansions, which stops macros from invoking themselves forever.
+const maxExpansionDepth = 64
+
+// maxParamCount is the maximum parameter count of a multi-line macro.
+const maxParamCount = 1 << 16
+
+// macroLabelPrefix starts the names of macro-local labels, which are unique to
+// each expansion. Like in NASM, labels starting with it aren't local labels,
+// and don't change their scope.
+const macroLabelPrefix = "..@"
+
+// A multiMacro represents a multi-line macro, defined between the %macro and
+// %endmacro directives.
+type multiMacro struct {
+	name string
+	// minParams and maxParams are the range of the macro's argument count. The
+	// maximum is -1 if it's unlimited.
+	minParams int
+	maxParams int
+	// greedy reports if the last parameter takes the rest of the arguments,
+	// along with their commas.
+	greedy bool
+	// defaults contains the default arguments of the parameters after the
+	// required ones.
+	defaults [][]Token
+	lines    [][]Token
+}
+
+// An expansion represents an invocation of a multi-line macro, which the
+// tokens of the expanded lines refer to.
+type expansion struct {
+	name   string
+	file   string   // the file of the invocation
+	pos    Position // the start of the invocation
+	end    Position // the position right after the end of the invocation
+	parent *expansion
+	depth  int
+}
+
+func (pp *Preprocessor) lookupMulti(tok Token) *multiMacro {
+	if !isName(tok) {
+		return nil
+	}
+
+	return pp.multiMacros[tok.Raw()]
+}
+
+// defineMulti handles a %macro directive line, reading the lines of the
+// macro's body up to its %endmacro.
+func (pp *Preprocessor) defineMulti(line []Token) []Token {
+	lines, end, bodyErr := pp.readBody(line[0], EndmacroDir)
+
+	m, err := parseMacroHeader(line)
+	if err != nil {
+		return errorLine(err, line[len(line)-1])
+	} else if bodyErr != nil {
+		return errorLine(bodyErr, end)
+	}
+
+	m.lines = lines
+	pp.multiMacros[m.name] = m
+
+	return []Token{end}
+}
+
+// readBody reads the lines of a block, like a multi-line macro's body, which
+// is started by the given directive, up to the directive ending it, whose
+// line's end it also returns. The blocks nested in it are read as a part of it.
+// The block has to end in the file it starts in.
+func (pp *Preprocessor) readBody(dir Token, endDir DirectiveID) ([][]Token, Token, *Error) {
+	lines := [][]Token{}
+	depth := 0
+	lxr := pp.lxr
+
+	for {
+		line := pp.readLine()
+		end := line[len(line)-1]
+
+		if first := line[0]; first.ID() == Directive {
+			switch DirectiveID(first.SpecID()) {
+			case DirectiveID(dir.SpecID()):
+				depth++
+			case endDir:
+				if depth == 0 {
+					return lines, end, nil
+				}
+
+				depth--
+			}
+		}
+
+		if end.ID() == EOF || pp.lxr != lxr {
+			// The ending directive is named after the starting one, like %endrep.
+			name := strings.ToLower(dir.Raw())
+			return nil, end, tokenError(dir, fmt.Sprintf("%s without %%end%s", name, name[1:]))
+		}
+
+		lines = append(lines, line)
+	}
+}
+
+// parseMacroHeader parses the name, the parameter count and the default
+// arguments of a multi-line macro, given by its %macro directive line. The
+// count can be a range, like "1-3", whose maximum can be unlimited, like
+// "1-*". The count can be followed by a '+', making the macro greedy.
+func parseMacroHeader(line []Token) (*multiMacro, *Error) {
+	name := line[1]
+	if !isName(name) {
+		return nil, tokenError(name, "expected macro name, found "+describe(name))
+	}
+
+	m := &multiMacro{name: name.Raw()}
+	rest := line[2:]
+
+	count, err := paramCount(rest[0])
+	if err != nil {
+		return nil, err
+	}
+
+	m.minParams, m.maxParams = count, count
+	rest = rest[1:]
+
+	if isOperator(rest[0], "-") {
+		if isOperator(rest[1], "*") {
+			m.maxParams = -1
+		} else if m.maxParams, err = paramCount(rest[1]); err != nil {
+			return nil, err
+		} else if m.maxParams < m.minParams {
+			return nil, tokenError(rest[1], "maximum parameter count is less than the minimum")
+		}
+
+		rest = rest[2:]
+	}
+
+	if isOperator(rest[0], "+") {
+		m.greedy = true
+		rest = rest[1:]
+	}
+
+	if len(rest) > 1 {
+		m.defaults = splitList(rest[:len(rest)-1], 0)
+		if m.maxParams != -1 && len(m.defaults) > m.maxParams-m.minParams {
+			return nil, tokenError(rest[0], "macro has more default arguments than optional parameters")
+		}
+	}
+
+	return m, nil
+}
+
+// paramCount parses a multi-line macro's parameter count.
+func paramCount(tok Token) (int, *Error) {
+	if tok.ID() != Decimal {
+		return 0, tokenError(tok, "expected parameter count, found "+describe(tok))
+	}
+
+	n, err := parseNumber(tok)
+	if err != nil || n > maxParamCount {
+		return 0, tokenError(tok, "parameter count is too large")
+	}
+
+	return int(n), nil
+}
+
+func isOperator(tok Token, op string) bool {
+	return tok.ID() == Operator && tok.Raw() == op
+}
+
+// invoke expands the multi-line macro invoked by the line, whose expanded
+// lines are preprocessed next, followed by the line's end.
+func (pp *Preprocessor) invoke(m *multiMacro, line []Token) []Token {
+	name, end := line[0], line[len(line)-1]
+	argToks := line[1 : len(line)-1]
+
+	exp := &expansion{name: m.name, file: name.File(), pos: name.Pos(), end: tokenEnd(name), parent: name.exp}
+	if len(argToks) != 0 {
+		exp.end = tokenEnd(argToks[len(argToks)-1])
+	}
+
+	if exp.parent != nil {
+		exp.depth = exp.parent.depth + 1
+	}
+
+	// The last parameter of a greedy macro takes the rest of the arguments.
+	limit := 0
+	if m.greedy && m.maxParams > 0 {
+		limit = m.maxParams
+	}
+
+	args := splitList(argToks, limit)
+
+	err := m.checkArgs(len(args))
+	if err == nil && exp.depth == maxExpansionDepth {
+		err = newError(SyntaxError, "macro expansions are nested too deeply")
+	}
+
+	if err != nil {
+		err.File, err.Pos, err.End = exp.file, exp.pos, exp.end
+		return errorLine(err, end)
+	}
+
+	count := len(args)
+	for i := count; i < m.maxParams; i++ {
+		var arg []Token
+		if def := i - m.minParams; def >= 0 && def < len(m.defaults) {
+			arg = m.defaults[def]
+		}
+
+		args = append(args, arg)
+	}
+
+	pp.expansions++
+
+	lines := make([][]Token, 0, len(m.lines)+1)
+	for _, line := range m.lines {
+		lines = append(lines, substituteLine(line, args, count, exp, pp.expansions))
+	}
+
+	pp.pending = append(append(lines, []Token{end}), pp.pending...)
+
+	return nil
+}
+
+// checkArgs returns an error if the macro can't be invoked with the given
+// number of arguments.
+func (m *multiMacro) checkArgs(n int) *Error {
+	var want string
+
+	switch {
+	case n >= m.minParams && (m.maxParams == -1 || n <= m.maxParams):
+		return nil
+	case m.maxParams == -1:
+		want = "at least " + plural(m.minParams, "argument")
+	case m.minParams == m.maxParams:
+		want = plural(m.minParams, "argument")
+	default:
+		want = fmt.Sprintf("%d to %d arguments", m.minParams, m.maxParams)
+	}
+
+	return newError(SyntaxError, fmt.Sprintf("macro '%s' expects %s, but got %d", m.name, want, n))
+}
+
+// substituteLine returns a line of a macro's body, with its parameters
+// replaced by the arguments, and its local labels given unique names. The
+// count is the number of arguments the macro was invoked with.
+func substituteLine(line []Token, args [][]Token, count int, exp *expansion, id int) []Token {
+	toks := make([]Token, 0, len(line))
+
+	for _, tok := range line {
+		switch tok.ID() {
+		case MacroParam:
+			n, err := strconv.Atoi(tok.Raw()[1:])
+			if err == nil && n == 0 {
+				tok.id, tok.raw = Decimal, strconv.Itoa(count)
+				break
+			}
+
+			// Parameters without arguments expand to nothing.
+			if err == nil && n <= len(args) {
+				toks = append(toks, args[n-1]...)
+			}
+
+			continue
+		case MacroLabel:
+			tok.id = Identifier
+			tok.raw = fmt.Sprintf("%s%d.%s", macroLabelPrefix, id, tok.Raw()[2:])
+		}
+
+		tok.exp = exp
+		toks = append(toks, tok)
+	}
+
+	return toks
+}
+
+// notes returns the notes pointing to the invocations of the expansion, and
+// the ones it's nested in.
+func (exp *expansion) notes() []Note {
+	notes := []Note{}
+	for ; exp != nil; exp = exp.parent {
+		notes = append(notes, Note{
+			File: exp.file,
+			Pos:  exp.pos,
+			End:  exp.end,
+			Msg:  fmt.Sprintf("in expansion of macro '%s'", exp.name),
+		})
+	}
+
+	return notes
+}
+
+// exprExpansion returns the innermost expansion of a multi-line macro the
+// expression was expanded from, or nil if there's none.
+func exprExpansion(expr Expr) *expansion {
+	var exp *expansion
+	visit := func(tok Token) {
+		if tok.exp != nil && (exp == nil || tok.exp.depth > exp.depth) {
+			exp = tok.exp
+		}
+	}
+
+	visit(expr.Root)
+	for _, tok := range expr.Children {
+		visit(tok)
+	}
+
+	visitNode(expr.Times, visit)
+	for _, op := range expr.Operands {
+		visit(op.Root)
+		visitNode(op.Expr, visit)
+	}
+
+	return exp
+}
+
+func visitNode(n *Node, visit func(Token)) {
+	if n == nil {
+		return
+	}
+
+	visit(n.Tok)
+	visitNode(n.Left, visit)
+	visitNode(n.Right, visit)
+}
+
+// splitList splits the tokens at the commas which aren't nested in
+// parentheses, or brackets. If the limit isn't zero, the tokens are split into
+// at most that many parts, the last of which keeps the rest of the commas.
+func splitList(toks []Token, limit int) [][]Token {
+	if len(toks) == 0 {
+		return nil
+	}
+
+	list := [][]Token{}
+	part := []Token{}
+	depth := 0
+
+	for _, tok := range toks {
+		switch tok.ID() {
+		case LParen, LBracket:
+			depth++
+		case RParen, RBracket:
+			depth--
+		case Comma:
+			if depth == 0 && (limit == 0 || len(list) < limit-1) {
+				list, part = append(list, part), []Token{}
+				continue
+			}
+		}
+
+		part = append(part, tok)
+	}
+
+	return append(list, part)
+}
+
+// plural returns the count followed by the noun, which is made plural unless
+// the count is one.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
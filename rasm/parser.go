@@ -1,47 +1,141 @@
 package rasm
 
-import "io"
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
 
 // A ExprID represents the type of an expression emitted by the [Parser].
 type ExprID uint
 
 const (
-	EOFExpr     ExprID = iota // represents an end of file
-	InstrExpr                 // represents an instruction expression
-	SectionExpr               // represents a section expression
-	LabelExpr                 // represents a label expression
-	IllegalExpr               // represents an illegal/unknown expression
+	EOFExpr       ExprID = iota // represents an end of file
+	InstrExpr                   // represents an instruction expression
+	SectionExpr                 // represents a section expression
+	LabelExpr                   // represents a label expression
+	DirectiveExpr               // represents a directive expression
+	IllegalExpr                 // represents an illegal/unknown expression
 )
 
 // Expr represents the expression parsed by the [Parser].
 type Expr struct {
-	ID       ExprID
-	Root     Token
+	ID   ExprID
+	Root Token
+	// Children contains the name of a section, or a constant, and the tokens
+	// of an illegal expression.
 	Children []Token
+	Operands []Operand // the operands of an instruction, or directive, expression
+	Err      *Error    // the error of an illegal expression
+	// Times is the count of a times prefix, which repeats an instruction, or
+	// data directive, expression. It's nil without the prefix.
+	Times *Node
+}
+
+// An OperandID represents the type of an [Operand].
+type OperandID uint
+
+const (
+	RegOperand OperandID = iota // represents a register operand
+	ImmOperand                  // represents an immediate operand
+	MemOperand                  // represents a memory operand
+)
+
+// An Operand represents an operand of an instruction expression.
+type Operand struct {
+	ID   OperandID
+	Root Token  // the first token of the operand
+	Expr *Node  // the value of the operand, or the address of a memory operand
+	Rel  bool   // reports if the memory operand is relative to RIP
+	GOT  *Token // the "..gotpcrel" token, if the memory operand refers to its label's GOT entry
+	Size uint   // the size of a memory operand, in bits, if it's given
+}
+
+// A Node is a node of an operand's expression tree. Leaf nodes contain a
+// single register, number, string or identifier token, while the rest contain an
+// operator token with its operands. Unary operators only have a left operand.
+type Node struct {
+	Tok   Token
+	Left  *Node
+	Right *Node
 }
 
-// A Parser is an object that takes the [Token]s emitted by the [Lexer]
-// to create known assembly expressions.
+// A Parser is an object that takes the [Token]s emitted by the [Lexer], and
+// preprocessed by the [Preprocessor], to create known assembly expressions.
 type Parser struct {
-	lxr  *Lexer
+	src tokenReader
+	// pp is the preprocessor the tokens are read from, which also resolves the
+	// files the code refers to. It's only nil for the parsers the preprocessor
+	// uses for its own expressions, which are never given to a [CodeGen].
+	pp   *Preprocessor
+	file string // the name of the main source file, for errors without a position
 	root Token
+	// toks contains the operand tokens of the expression being parsed, which
+	// are reported in case of an illegal expression.
+	toks   []Token
+	peeked *Token
+	last   Token // the last token read
+}
+
+// A tokenReader is an object which emits the tokens read by the [Parser].
+type tokenReader interface {
+	Next() Token
+}
+
+// A tokenList is a [tokenReader] which emits a list of tokens, followed by the
+// given end of the list.
+type tokenList struct {
+	toks []Token
+	end  Token
+}
+
+func (l *tokenList) Next() Token {
+	if len(l.toks) == 0 {
+		return l.end
+	}
+
+	tok := l.toks[0]
+	l.toks = l.toks[1:]
+
+	return tok
 }
 
 // NewParser creates a new parser based on the given [io.Reader].
 func NewParser(rd io.Reader) *Parser {
-	return &Parser{lxr: NewLexer(rd)}
+	return NewParserLexer(NewLexer(rd))
 }
 
 // NewParserLexer creates a new parser based on the given [Lexer].
 func NewParserLexer(lxr *Lexer) *Parser {
-	return &Parser{lxr: lxr}
+	return NewParserPreprocessor(NewPreprocessor(lxr))
+}
+
+// NewParserPreprocessor creates a new parser based on the given [Preprocessor].
+func NewParserPreprocessor(pp *Preprocessor) *Parser {
+	return &Parser{src: pp, pp: pp, file: pp.lxr.file}
 }
 
 // Next parses and returns the next expression. If the file has been fully
 // read, Next will always return a [EOFExpr] expression.
+//
+// After an [IllegalExpr] expression, the rest of its line is skipped, so the
+// parsing resumes with the next line.
 func (p *Parser) Next() Expr {
+	expr := p.parseLine()
+	if expr.ID == IllegalExpr {
+		p.skipLine()
+	}
+
+	return expr
+}
+
+func (p *Parser) parseLine() Expr {
+	p.toks = []Token{}
+
 	for {
-		tok := p.lxr.Next()
+		tok := p.read()
 		switch tok.ID() {
 		case Newline:
 			continue
@@ -50,70 +144,395 @@ func (p *Parser) Next() Expr {
 			return p.parseSection()
 		case Instruction:
 			p.root = tok
-			return p.parseInstruction()
+			return p.parseOperands(InstrExpr)
+		case Directive:
+			p.root = tok
+			switch DirectiveID(tok.SpecID()) {
+			case AssignDir:
+				return p.parseAssign()
+			case TimesDir:
+				return p.parseTimes()
+			}
+
+			return p.parseOperands(DirectiveExpr)
 		case Identifier:
 			p.root = tok
 			return p.parseLabel()
 		case EOF:
-			return Expr{EOFExpr, tok, nil}
+			return Expr{ID: EOFExpr, Root: tok}
 		}
 
-		return Expr{ID: IllegalExpr, Root: tok}
+		return Expr{ID: IllegalExpr, Root: tok, Err: p.syntaxError("", tok)}
 	}
 }
 
-func (p *Parser) parseInstruction() Expr {
-	children := []Token{}
+// parseOperands parses the comma separated operands of an instruction, or
+// directive, expression.
+func (p *Parser) parseOperands(id ExprID) Expr {
+	operands := []Operand{}
+	p.toks = []Token{}
 
 	for {
-		op := p.lxr.Next()
-		switch op.ID() {
+		tok := p.next()
+		switch tok.ID() {
 		case Newline, EOF:
-			return Expr{ID: InstrExpr, Root: p.root, Children: children}
-		case Identifier, Decimal, Hex, Octal, Register:
-			children = append(children, op)
-		default:
-			children = append(children, op)
-			return Expr{
-				ID:       IllegalExpr,
-				Root:     p.root,
-				Children: append([]Token{{raw: "expected operand or '\\n'"}}, children...),
-			}
+			return Expr{ID: id, Root: p.root, Operands: operands}
 		}
 
-		delim := p.lxr.Next()
+		op, bad := p.parseOperand(tok)
+		if bad != nil {
+			return *bad
+		}
+
+		operands = append(operands, op)
+
+		delim := p.read()
 		switch delim.ID() {
 		case Newline, EOF:
-			return Expr{ID: InstrExpr, Root: p.root, Children: children}
+			return Expr{ID: id, Root: p.root, Operands: operands}
 		case Comma:
 			continue
 		default:
-			children = append(children, delim)
-			return Expr{
-				ID:       IllegalExpr,
-				Root:     p.root,
-				Children: append([]Token{{raw: "expected '\\n' or ','"}}, children...),
+			return p.illegal("',' or end of line", delim)
+		}
+	}
+}
+
+// parseOperand parses an operand starting with the given token. If the
+// operand is malformed, it returns the illegal expression to be emitted.
+func (p *Parser) parseOperand(tok Token) (Operand, *Expr) {
+	switch tok.ID() {
+	case Register, Identifier, Here, Decimal, Hex, Octal, Binary, String, LParen, Operator:
+		val, bad := p.parseExpr(tok, 0)
+		if bad != nil {
+			return Operand{}, bad
+		}
+
+		if val.Left == nil && val.Tok.ID() == Register {
+			return Operand{ID: RegOperand, Root: tok, Expr: val}, nil
+		}
+
+		return Operand{ID: ImmOperand, Root: tok, Expr: val}, nil
+	case Size:
+		// The size keyword can be followed by an optional "ptr".
+		lbrack := p.next()
+		if lbrack.ID() == Identifier && strings.ToLower(lbrack.Raw()) == "ptr" {
+			lbrack = p.next()
+		}
+
+		if lbrack.ID() != LBracket {
+			illegal := p.illegal("'['", lbrack)
+			return Operand{}, &illegal
+		}
+
+		op, bad := p.parseMemory(tok)
+		op.Size = SizeID(tok.SpecID()).Bits()
+
+		return op, bad
+	case LBracket:
+		return p.parseMemory(tok)
+	}
+
+	illegal := p.illegal("operand or end of line", tok)
+	return Operand{}, &illegal
+}
+
+// parseMemory parses a memory operand, starting with the given token, whose
+// '[' was already read.
+func (p *Parser) parseMemory(root Token) (Operand, *Expr) {
+	start := p.next()
+
+	rel := false
+	if start.ID() == Identifier && strings.ToLower(start.Raw()) == "rel" {
+		next := p.peek()
+		if next.ID() != RBracket && next.ID() != Operator {
+			rel = true
+			start = p.next()
+		}
+	}
+
+	addr, bad := p.parseExpr(start, 0)
+	if bad != nil {
+		return Operand{}, bad
+	}
+
+	// The address of a label's GOT entry is given by "wrt ..gotpcrel", like in
+	// NASM.
+	var got *Token
+	if wrt := p.peek(); wrt.ID() == Identifier && strings.ToLower(wrt.Raw()) == "wrt" {
+		p.next()
+		sym := p.next()
+		if sym.ID() != Identifier || strings.ToLower(sym.Raw()) != "..gotpcrel" {
+			illegal := p.illegal("'..gotpcrel'", sym)
+			return Operand{}, &illegal
+		}
+
+		got = &sym
+	}
+
+	if rbrack := p.next(); rbrack.ID() != RBracket {
+		illegal := p.illegal("']'", rbrack)
+		return Operand{}, &illegal
+	}
+
+	return Operand{ID: MemOperand, Root: root, Expr: addr, Rel: rel, GOT: got}, nil
+}
+
+// binaryOps contains the binary operators grouped by their precedence, from the
+// lowest to the highest.
+var binaryOps = [][]string{
+	{"||"},
+	{"^^"},
+	{"&&"},
+	{"==", "=", "!=", "<>", "<", "<=", ">", ">="},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parseExpr parses an expression, starting with the given token, whose binary
+// operators have at least the precedence of the given level of [binaryOps].
+func (p *Parser) parseExpr(tok Token, level int) (*Node, *Expr) {
+	if level == len(binaryOps) {
+		return p.parseUnary(tok)
+	}
+
+	left, bad := p.parseExpr(tok, level+1)
+	if bad != nil {
+		return nil, bad
+	}
+
+	for {
+		op := p.peek()
+		if op.ID() != Operator || !slices.Contains(binaryOps[level], op.Raw()) {
+			return left, nil
+		}
+		p.next()
+
+		right, bad := p.parseExpr(p.next(), level+1)
+		if bad != nil {
+			return nil, bad
+		}
+
+		left = &Node{Tok: op, Left: left, Right: right}
+	}
+}
+
+// parseUnary parses a single value, a parenthesized expression, or a unary
+// operator applied to either of them.
+func (p *Parser) parseUnary(tok Token) (*Node, *Expr) {
+	switch tok.ID() {
+	case Register, Identifier, Here, Decimal, Hex, Octal, Binary, String:
+		return &Node{Tok: tok}, nil
+	case LParen:
+		val, bad := p.parseExpr(p.next(), 0)
+		if bad != nil {
+			return nil, bad
+		}
+
+		if rparen := p.next(); rparen.ID() != RParen {
+			illegal := p.illegal("')'", rparen)
+			return nil, &illegal
+		}
+
+		return val, nil
+	case Operator:
+		switch tok.Raw() {
+		case "-", "+", "~", "!":
+			operand, bad := p.parseUnary(p.next())
+			if bad != nil {
+				return nil, bad
 			}
+
+			return &Node{Tok: tok, Left: operand}, nil
 		}
 	}
+
+	illegal := p.illegal("register or number", tok)
+	return nil, &illegal
+}
+
+// next reads the next token and records it as part of the current expression.
+func (p *Parser) next() Token {
+	tok := p.read()
+	p.toks = append(p.toks, tok)
+
+	return tok
+}
+
+// read returns the peeked token, if it exists, or the next token from the
+// preprocessor.
+func (p *Parser) read() Token {
+	if p.peeked != nil {
+		p.last = *p.peeked
+		p.peeked = nil
+	} else {
+		p.last = p.src.Next()
+	}
+
+	return p.last
+}
+
+// skipLine skips the tokens until the end of the current line, unless it was
+// already read.
+func (p *Parser) skipLine() {
+	for p.last.ID() != Newline && p.last.ID() != EOF {
+		p.read()
+	}
+}
+
+func (p *Parser) peek() Token {
+	if p.peeked == nil {
+		tok := p.src.Next()
+		p.peeked = &tok
+	}
+
+	return *p.peeked
+}
+
+// illegal creates an illegal expression, whose children are the tokens of the
+// current expression, up to the unexpected token. The want string describes
+// what was expected instead of the token.
+func (p *Parser) illegal(want string, bad Token) Expr {
+	children := p.toks
+	if len(children) == 0 || children[len(children)-1] != bad {
+		children = append(children, bad)
+	}
+
+	return Expr{ID: IllegalExpr, Root: p.root, Children: children, Err: p.syntaxError(want, bad)}
+}
+
+// syntaxError returns an error located at the unexpected token. Illegal tokens
+// emitted by the [Lexer] carry their own message, unless they're a single
+// unknown character.
+func (p *Parser) syntaxError(want string, bad Token) *Error {
+	err := &Error{
+		Kind: SyntaxError,
+		File: bad.File(),
+		Pos:  bad.Pos(),
+		End:  tokenEnd(bad),
+	}
+
+	switch {
+	case bad.ID() == Illegal && utf8.RuneCountInString(bad.Raw()) == 1:
+		err.Msg = fmt.Sprintf("unexpected character '%s'", bad.Raw())
+	case bad.ID() == Illegal:
+		err.Msg = bad.Raw()
+	case want == "":
+		err.Msg = "unexpected " + describe(bad)
+	default:
+		err.Msg = fmt.Sprintf("expected %s, found %s", want, describe(bad))
+	}
+
+	return err
+}
+
+// describe returns a description of the token to be used in errors.
+func describe(tok Token) string {
+	switch tok.ID() {
+	case EOF:
+		return "end of file"
+	case Newline:
+		return "end of line"
+	}
+
+	return "'" + tok.Raw() + "'"
 }
 
 func (p *Parser) parseSection() Expr {
-	ident := p.lxr.Next()
+	ident := p.read()
 	if ident.ID() != Identifier {
-		return Expr{
-			ID:       IllegalExpr,
-			Root:     p.root,
-			Children: []Token{{raw: "expected identifier"}, ident},
-		}
+		return p.illegal("identifier", ident)
 	}
 	return Expr{ID: SectionExpr, Root: p.root, Children: []Token{ident}}
 }
 
+// parseLabel parses a label, or an equ constant definition, whose name is the
+// root token. The name of a constant can be followed by a colon, like a label.
 func (p *Parser) parseLabel() Expr {
-	colon := p.lxr.Next()
-	if colon.ID() != Colon {
-		return Expr{ID: IllegalExpr, Root: p.root, Children: []Token{{raw: "expected ':'"}, colon}}
+	name := p.root
+
+	colon := p.read()
+	if colon.ID() == Colon {
+		if equ := p.peek(); equ.ID() != Directive || DirectiveID(equ.SpecID()) != EquDir {
+			return Expr{ID: LabelExpr, Root: p.root, Children: nil}
+		}
+
+		colon = p.read()
+	}
+
+	switch {
+	case colon.ID() == Directive && DirectiveID(colon.SpecID()) == EquDir:
+	case colon.ID() == Newline || colon.ID() == EOF:
+		return p.illegal("':'", colon)
+	default:
+		// Anything else following the name means it was meant as an instruction.
+		illegal := p.illegal("", colon)
+		illegal.Err = &Error{
+			Kind: UnknownMnemonic,
+			File: name.File(),
+			Pos:  name.Pos(),
+			End:  tokenEnd(name),
+			Msg:  fmt.Sprintf("unknown mnemonic '%s'", name.Raw()),
+		}
+
+		return illegal
+	}
+
+	p.root = colon
+	expr := p.parseOperands(DirectiveExpr)
+	if expr.ID == DirectiveExpr {
+		expr.Children = []Token{name}
+	}
+
+	return expr
+}
+
+// parseTimes parses the count of a times prefix, and the instruction, or data
+// directive, expression it repeats.
+func (p *Parser) parseTimes() Expr {
+	count, bad := p.parseExpr(p.next(), 0)
+	if bad != nil {
+		return *bad
 	}
-	return Expr{ID: LabelExpr, Root: p.root, Children: nil}
+
+	tok := p.next()
+	_, isData := dataSizes[DirectiveID(tok.SpecID())]
+	_, isReserve := reserveSizes[DirectiveID(tok.SpecID())]
+
+	var expr Expr
+	switch {
+	case tok.ID() == Instruction:
+		p.root = tok
+		expr = p.parseOperands(InstrExpr)
+	case tok.ID() == Directive && (isData || isReserve):
+		p.root = tok
+		expr = p.parseOperands(DirectiveExpr)
+	default:
+		return p.illegal("instruction or data directive", tok)
+	}
+
+	if expr.ID != IllegalExpr {
+		expr.Times = count
+	}
+
+	return expr
+}
+
+// parseAssign parses the name and value of an %assign directive.
+func (p *Parser) parseAssign() Expr {
+	name := p.read()
+	if name.ID() != Identifier {
+		return p.illegal("identifier", name)
+	}
+
+	expr := p.parseOperands(DirectiveExpr)
+	if expr.ID == DirectiveExpr {
+		expr.Children = []Token{name}
+	}
+
+	return expr
 }
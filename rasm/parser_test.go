@@ -20,9 +20,9 @@ func TestParser_Next(t *testing.T) {
 			name: "Should parse section expression",
 			rd:   strings.NewReader("\nsection .bss"),
 			want: rasm.Expr{
-				rasm.SectionExpr,
-				rasm.NewToken(rasm.Position{2, 0}, rasm.Section, "section"),
-				[]rasm.Token{
+				ID:   rasm.SectionExpr,
+				Root: rasm.NewToken(rasm.Position{2, 0}, rasm.Section, "section"),
+				Children: []rasm.Token{
 					rasm.NewToken(rasm.Position{2, 8}, rasm.Identifier, ".bss"),
 				},
 			},
@@ -31,23 +31,190 @@ func TestParser_Next(t *testing.T) {
 			name: "Should parse instruction expression",
 			rd:   strings.NewReader("add"),
 			want: rasm.Expr{
-				rasm.InstrExpr,
-				rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.ADD)|rasm.Instruction, "add"),
-				[]rasm.Token{},
+				ID:       rasm.InstrExpr,
+				Root:     rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.ADD)|rasm.Instruction, "add"),
+				Operands: []rasm.Operand{},
 			},
 		},
 		{
 			name: "Should parse instruction expression (with operands)",
 			rd:   strings.NewReader("mov eax, 512, 0xff, 0o777, some_ident"),
 			want: rasm.Expr{
-				rasm.InstrExpr,
-				rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
-				[]rasm.Token{
-					rasm.NewToken(rasm.Position{1, 4}, rasm.TokenID(x86.EAX)|rasm.Register, "eax"),
-					rasm.NewToken(rasm.Position{1, 9}, rasm.Decimal, "512"),
-					rasm.NewToken(rasm.Position{1, 14}, rasm.Hex, "ff"),
-					rasm.NewToken(rasm.Position{1, 20}, rasm.Octal, "777"),
-					rasm.NewToken(rasm.Position{1, 27}, rasm.Identifier, "some_ident"),
+				ID:   rasm.InstrExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
+				Operands: []rasm.Operand{
+					leafOperand(rasm.RegOperand, rasm.NewToken(rasm.Position{1, 4}, rasm.TokenID(x86.EAX)|rasm.Register, "eax")),
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 9}, rasm.Decimal, "512")),
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 14}, rasm.Hex, "ff")),
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 20}, rasm.Octal, "777")),
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 27}, rasm.Identifier, "some_ident")),
+				},
+			},
+		},
+		{
+			name: "Should parse instruction expression (with memory operands)",
+			rd:   strings.NewReader("mov [rbx + rcx*4 - 0x10], al"),
+			want: rasm.Expr{
+				ID:   rasm.InstrExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
+				Operands: []rasm.Operand{
+					{
+						ID:   rasm.MemOperand,
+						Root: rasm.NewToken(rasm.Position{1, 4}, rasm.LBracket, "["),
+						Expr: &rasm.Node{
+							Tok: rasm.NewToken(rasm.Position{1, 17}, rasm.Operator, "-"),
+							Left: &rasm.Node{
+								Tok:  rasm.NewToken(rasm.Position{1, 9}, rasm.Operator, "+"),
+								Left: &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 5}, rasm.TokenID(x86.RBX)|rasm.Register, "rbx")},
+								Right: &rasm.Node{
+									Tok:   rasm.NewToken(rasm.Position{1, 14}, rasm.Operator, "*"),
+									Left:  &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 11}, rasm.TokenID(x86.RCX)|rasm.Register, "rcx")},
+									Right: &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 15}, rasm.Decimal, "4")},
+								},
+							},
+							Right: &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 19}, rasm.Hex, "10")},
+						},
+					},
+					leafOperand(rasm.RegOperand, rasm.NewToken(rasm.Position{1, 26}, rasm.TokenID(x86.AL)|rasm.Register, "al")),
+				},
+			},
+		},
+		{
+			name: "Should parse instruction expression (with sized memory operand)",
+			rd:   strings.NewReader("mov qword ptr [rax], 1"),
+			want: rasm.Expr{
+				ID:   rasm.InstrExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
+				Operands: []rasm.Operand{
+					{
+						ID:   rasm.MemOperand,
+						Root: rasm.NewToken(rasm.Position{1, 4}, rasm.TokenID(rasm.QwordSize)|rasm.Size, "qword"),
+						Expr: &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 15}, rasm.TokenID(x86.RAX)|rasm.Register, "rax")},
+						Size: 64,
+					},
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 21}, rasm.Decimal, "1")),
+				},
+			},
+		},
+		{
+			name: "Should parse instruction expression (with RIP-relative operand)",
+			rd:   strings.NewReader("lea rsi, [rel msg]"),
+			want: rasm.Expr{
+				ID:   rasm.InstrExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.LEA)|rasm.Instruction, "lea"),
+				Operands: []rasm.Operand{
+					leafOperand(rasm.RegOperand, rasm.NewToken(rasm.Position{1, 4}, rasm.TokenID(x86.RSI)|rasm.Register, "rsi")),
+					{
+						ID:   rasm.MemOperand,
+						Root: rasm.NewToken(rasm.Position{1, 9}, rasm.LBracket, "["),
+						Expr: &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 14}, rasm.Identifier, "msg")},
+						Rel:  true,
+					},
+				},
+			},
+		},
+		{
+			name: "Should parse instruction expression (with GOT-relative operand)",
+			rd:   strings.NewReader("mov rax, [rel puts wrt ..gotpcrel]"),
+			want: rasm.Expr{
+				ID:   rasm.InstrExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
+				Operands: []rasm.Operand{
+					leafOperand(rasm.RegOperand, rasm.NewToken(rasm.Position{1, 4}, rasm.TokenID(x86.RAX)|rasm.Register, "rax")),
+					{
+						ID:   rasm.MemOperand,
+						Root: rasm.NewToken(rasm.Position{1, 9}, rasm.LBracket, "["),
+						Expr: &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 14}, rasm.Identifier, "puts")},
+						Rel:  true,
+						GOT:  tokenPtr(rasm.NewToken(rasm.Position{1, 23}, rasm.Identifier, "..gotpcrel")),
+					},
+				},
+			},
+		},
+		{
+			name: "Should parse instruction expression (with immediate expression)",
+			rd:   strings.NewReader("mov eax, (1 << 12) | ~3"),
+			want: rasm.Expr{
+				ID:   rasm.InstrExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
+				Operands: []rasm.Operand{
+					leafOperand(rasm.RegOperand, rasm.NewToken(rasm.Position{1, 4}, rasm.TokenID(x86.EAX)|rasm.Register, "eax")),
+					{
+						ID:   rasm.ImmOperand,
+						Root: rasm.NewToken(rasm.Position{1, 9}, rasm.LParen, "("),
+						Expr: &rasm.Node{
+							Tok: rasm.NewToken(rasm.Position{1, 19}, rasm.Operator, "|"),
+							Left: &rasm.Node{
+								Tok:   rasm.NewToken(rasm.Position{1, 12}, rasm.Operator, "<<"),
+								Left:  &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 10}, rasm.Decimal, "1")},
+								Right: &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 15}, rasm.Decimal, "12")},
+							},
+							Right: &rasm.Node{
+								Tok:  rasm.NewToken(rasm.Position{1, 21}, rasm.Operator, "~"),
+								Left: &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 22}, rasm.Decimal, "3")},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Should parse directive expression",
+			rd:   strings.NewReader("extern puts, printf"),
+			want: rasm.Expr{
+				ID:   rasm.DirectiveExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(rasm.ExternDir)|rasm.Directive, "extern"),
+				Operands: []rasm.Operand{
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 7}, rasm.Identifier, "puts")),
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 13}, rasm.Identifier, "printf")),
+				},
+			},
+		},
+		{
+			name: "Should parse data directive expression",
+			rd:   strings.NewReader(`db "hi", 10`),
+			want: rasm.Expr{
+				ID:   rasm.DirectiveExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(rasm.DbDir)|rasm.Directive, "db"),
+				Operands: []rasm.Operand{
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 3}, rasm.String, `"hi"`)),
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 9}, rasm.Decimal, "10")),
+				},
+			},
+		},
+		{
+			name: "Should parse equ expression",
+			rd:   strings.NewReader("SYS_WRITE equ 1"),
+			want: rasm.Expr{
+				ID:       rasm.DirectiveExpr,
+				Root:     rasm.NewToken(rasm.Position{1, 10}, rasm.TokenID(rasm.EquDir)|rasm.Directive, "equ"),
+				Children: []rasm.Token{rasm.NewToken(rasm.Position{1, 0}, rasm.Identifier, "SYS_WRITE")},
+				Operands: []rasm.Operand{
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 14}, rasm.Decimal, "1")),
+				},
+			},
+		},
+		{
+			name: "Should parse equ expression (with colon)",
+			rd:   strings.NewReader("len: equ 1"),
+			want: rasm.Expr{
+				ID:       rasm.DirectiveExpr,
+				Root:     rasm.NewToken(rasm.Position{1, 5}, rasm.TokenID(rasm.EquDir)|rasm.Directive, "equ"),
+				Children: []rasm.Token{rasm.NewToken(rasm.Position{1, 0}, rasm.Identifier, "len")},
+				Operands: []rasm.Operand{
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 9}, rasm.Decimal, "1")),
+				},
+			},
+		},
+		{
+			name: "Should parse %assign expression",
+			rd:   strings.NewReader("%assign i i"),
+			want: rasm.Expr{
+				ID:       rasm.DirectiveExpr,
+				Root:     rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(rasm.AssignDir)|rasm.Directive, "%assign"),
+				Children: []rasm.Token{rasm.NewToken(rasm.Position{1, 8}, rasm.Identifier, "i")},
+				Operands: []rasm.Operand{
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 10}, rasm.Identifier, "i")),
 				},
 			},
 		},
@@ -55,76 +222,235 @@ func TestParser_Next(t *testing.T) {
 			name: "Should parse label expression",
 			rd:   strings.NewReader("label:"),
 			want: rasm.Expr{
-				rasm.LabelExpr,
-				rasm.NewToken(rasm.Position{1, 0}, rasm.Identifier, "label"),
-				nil,
+				ID:       rasm.LabelExpr,
+				Root:     rasm.NewToken(rasm.Position{1, 0}, rasm.Identifier, "label"),
+				Children: nil,
 			},
 		},
 		{
 			name: "Should parse EOF",
 			rd:   strings.NewReader(""),
 			want: rasm.Expr{
-				rasm.EOFExpr,
-				rasm.NewToken(rasm.Position{1, 0}, rasm.EOF, ""),
-				nil,
+				ID:       rasm.EOFExpr,
+				Root:     rasm.NewToken(rasm.Position{1, 0}, rasm.EOF, ""),
+				Children: nil,
 			},
 		},
 		{
 			name: "Should not parse illegal token",
 			rd:   strings.NewReader("\\"),
 			want: rasm.Expr{
-				rasm.IllegalExpr,
-				rasm.NewToken(rasm.Position{1, 0}, rasm.Illegal, "\\"),
-				nil,
+				ID:       rasm.IllegalExpr,
+				Root:     rasm.NewToken(rasm.Position{1, 0}, rasm.Illegal, "\\"),
+				Children: nil,
+				Err: &rasm.Error{
+					Kind: rasm.SyntaxError,
+					Pos:  rasm.Position{1, 0},
+					End:  rasm.Position{1, 1},
+					Msg:  "unexpected character '\\'",
+				},
 			},
 		},
 		{
 			name: "Should not parse malformed section expression",
 			rd:   strings.NewReader("section :"),
 			want: rasm.Expr{
-				rasm.IllegalExpr,
-				rasm.NewToken(rasm.Position{1, 0}, rasm.Section, "section"),
-				[]rasm.Token{
-					rasm.NewToken(rasm.Position{0, 0}, 0, "expected identifier"),
+				ID:   rasm.IllegalExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.Section, "section"),
+				Children: []rasm.Token{
 					rasm.NewToken(rasm.Position{1, 8}, rasm.Colon, ":"),
 				},
+				Err: &rasm.Error{
+					Kind: rasm.SyntaxError,
+					Pos:  rasm.Position{1, 8},
+					End:  rasm.Position{1, 9},
+					Msg:  "expected identifier, found ':'",
+				},
 			},
 		},
 		{
 			name: "Should not parse malformed instruction expression (expect operand)",
 			rd:   strings.NewReader("mov 512,,"),
 			want: rasm.Expr{
-				rasm.IllegalExpr,
-				rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
-				[]rasm.Token{
-					rasm.NewToken(rasm.Position{0, 0}, 0, "expected operand or '\\n'"),
+				ID:   rasm.IllegalExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
+				Children: []rasm.Token{
 					rasm.NewToken(rasm.Position{1, 4}, rasm.Decimal, "512"),
 					rasm.NewToken(rasm.Position{1, 8}, rasm.Comma, ","),
 				},
+				Err: &rasm.Error{
+					Kind: rasm.SyntaxError,
+					Pos:  rasm.Position{1, 8},
+					End:  rasm.Position{1, 9},
+					Msg:  "expected operand or end of line, found ','",
+				},
 			},
 		},
 		{
 			name: "Should not parse malformed instruction expression (expect delimiter)",
 			rd:   strings.NewReader("mov 512:"),
 			want: rasm.Expr{
-				rasm.IllegalExpr,
-				rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
-				[]rasm.Token{
-					rasm.NewToken(rasm.Position{0, 0}, 0, "expected '\\n' or ','"),
+				ID:   rasm.IllegalExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
+				Children: []rasm.Token{
 					rasm.NewToken(rasm.Position{1, 4}, rasm.Decimal, "512"),
 					rasm.NewToken(rasm.Position{1, 7}, rasm.Colon, ":"),
 				},
+				Err: &rasm.Error{
+					Kind: rasm.SyntaxError,
+					Pos:  rasm.Position{1, 7},
+					End:  rasm.Position{1, 8},
+					Msg:  "expected ',' or end of line, found ':'",
+				},
+			},
+		},
+		{
+			name: "Should not parse unterminated memory operand",
+			rd:   strings.NewReader("mov eax, [rbx,"),
+			want: rasm.Expr{
+				ID:   rasm.IllegalExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
+				Children: []rasm.Token{
+					rasm.NewToken(rasm.Position{1, 4}, rasm.TokenID(x86.EAX)|rasm.Register, "eax"),
+					rasm.NewToken(rasm.Position{1, 9}, rasm.LBracket, "["),
+					rasm.NewToken(rasm.Position{1, 10}, rasm.TokenID(x86.RBX)|rasm.Register, "rbx"),
+					rasm.NewToken(rasm.Position{1, 13}, rasm.Comma, ","),
+				},
+				Err: &rasm.Error{
+					Kind: rasm.SyntaxError,
+					Pos:  rasm.Position{1, 13},
+					End:  rasm.Position{1, 14},
+					Msg:  "expected ']', found ','",
+				},
+			},
+		},
+		{
+			name: "Should not parse memory operand relative to an unknown symbol",
+			rd:   strings.NewReader("mov rax, [rel puts wrt got]"),
+			want: rasm.Expr{
+				ID:   rasm.IllegalExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
+				Children: []rasm.Token{
+					rasm.NewToken(rasm.Position{1, 4}, rasm.TokenID(x86.RAX)|rasm.Register, "rax"),
+					rasm.NewToken(rasm.Position{1, 9}, rasm.LBracket, "["),
+					rasm.NewToken(rasm.Position{1, 10}, rasm.Identifier, "rel"),
+					rasm.NewToken(rasm.Position{1, 14}, rasm.Identifier, "puts"),
+					rasm.NewToken(rasm.Position{1, 19}, rasm.Identifier, "wrt"),
+					rasm.NewToken(rasm.Position{1, 23}, rasm.Identifier, "got"),
+				},
+				Err: &rasm.Error{
+					Kind: rasm.SyntaxError,
+					Pos:  rasm.Position{1, 23},
+					End:  rasm.Position{1, 26},
+					Msg:  "expected '..gotpcrel', found 'got'",
+				},
+			},
+		},
+		{
+			name: "Should not parse unclosed parentheses",
+			rd:   strings.NewReader("mov eax, (1 + 2"),
+			want: rasm.Expr{
+				ID:   rasm.IllegalExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
+				Children: []rasm.Token{
+					rasm.NewToken(rasm.Position{1, 4}, rasm.TokenID(x86.EAX)|rasm.Register, "eax"),
+					rasm.NewToken(rasm.Position{1, 9}, rasm.LParen, "("),
+					rasm.NewToken(rasm.Position{1, 10}, rasm.Decimal, "1"),
+					rasm.NewToken(rasm.Position{1, 12}, rasm.Operator, "+"),
+					rasm.NewToken(rasm.Position{1, 14}, rasm.Decimal, "2"),
+					rasm.NewToken(rasm.Position{1, 15}, rasm.EOF, ""),
+				},
+				Err: &rasm.Error{
+					Kind: rasm.SyntaxError,
+					Pos:  rasm.Position{1, 15},
+					End:  rasm.Position{1, 15},
+					Msg:  "expected ')', found end of file",
+				},
+			},
+		},
+		{
+			name: "Should not parse size keyword without memory operand",
+			rd:   strings.NewReader("mov byte 5"),
+			want: rasm.Expr{
+				ID:   rasm.IllegalExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(x86.MOV)|rasm.Instruction, "mov"),
+				Children: []rasm.Token{
+					rasm.NewToken(rasm.Position{1, 4}, rasm.TokenID(rasm.ByteSize)|rasm.Size, "byte"),
+					rasm.NewToken(rasm.Position{1, 9}, rasm.Decimal, "5"),
+				},
+				Err: &rasm.Error{
+					Kind: rasm.SyntaxError,
+					Pos:  rasm.Position{1, 9},
+					End:  rasm.Position{1, 10},
+					Msg:  "expected '[', found '5'",
+				},
+			},
+		},
+		{
+			name: "Should parse times prefix",
+			rd:   strings.NewReader("times 2 * 4 db 0"),
+			want: rasm.Expr{
+				ID:   rasm.DirectiveExpr,
+				Root: rasm.NewToken(rasm.Position{1, 12}, rasm.TokenID(rasm.DbDir)|rasm.Directive, "db"),
+				Operands: []rasm.Operand{
+					leafOperand(rasm.ImmOperand, rasm.NewToken(rasm.Position{1, 15}, rasm.Decimal, "0")),
+				},
+				Times: &rasm.Node{
+					Tok:   rasm.NewToken(rasm.Position{1, 8}, rasm.Operator, "*"),
+					Left:  &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 6}, rasm.Decimal, "2")},
+					Right: &rasm.Node{Tok: rasm.NewToken(rasm.Position{1, 10}, rasm.Decimal, "4")},
+				},
+			},
+		},
+		{
+			name: "Should not parse times prefix without instruction or data",
+			rd:   strings.NewReader("times 3 global x"),
+			want: rasm.Expr{
+				ID:   rasm.IllegalExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.TokenID(rasm.TimesDir)|rasm.Directive, "times"),
+				Children: []rasm.Token{
+					rasm.NewToken(rasm.Position{1, 6}, rasm.Decimal, "3"),
+					rasm.NewToken(rasm.Position{1, 8}, rasm.TokenID(rasm.GlobalDir)|rasm.Directive, "global"),
+				},
+				Err: &rasm.Error{
+					Kind: rasm.SyntaxError,
+					Pos:  rasm.Position{1, 8},
+					End:  rasm.Position{1, 14},
+					Msg:  "expected instruction or data directive, found 'global'",
+				},
 			},
 		},
 		{
 			name: "Should not parse malformed label expression",
-			rd:   strings.NewReader("label,"),
+			rd:   strings.NewReader("label\n"),
 			want: rasm.Expr{
-				rasm.IllegalExpr,
-				rasm.NewToken(rasm.Position{1, 0}, rasm.Identifier, "label"),
-				[]rasm.Token{
-					rasm.NewToken(rasm.Position{0, 0}, 0, "expected ':'"),
-					rasm.NewToken(rasm.Position{1, 5}, rasm.Comma, ","),
+				ID:   rasm.IllegalExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.Identifier, "label"),
+				Children: []rasm.Token{
+					rasm.NewToken(rasm.Position{1, 5}, rasm.Newline, "\\n"),
+				},
+				Err: &rasm.Error{
+					Kind: rasm.SyntaxError,
+					Pos:  rasm.Position{1, 5},
+					End:  rasm.Position{1, 6},
+					Msg:  "expected ':', found end of line",
+				},
+			},
+		},
+		{
+			name: "Should not parse unknown mnemonic",
+			rd:   strings.NewReader("foo eax, 1"),
+			want: rasm.Expr{
+				ID:   rasm.IllegalExpr,
+				Root: rasm.NewToken(rasm.Position{1, 0}, rasm.Identifier, "foo"),
+				Children: []rasm.Token{
+					rasm.NewToken(rasm.Position{1, 4}, rasm.TokenID(x86.EAX)|rasm.Register, "eax"),
+				},
+				Err: &rasm.Error{
+					Kind: rasm.UnknownMnemonic,
+					Pos:  rasm.Position{1, 0},
+					End:  rasm.Position{1, 3},
+					Msg:  "unknown mnemonic 'foo'",
 				},
 			},
 		},
@@ -140,3 +466,29 @@ func TestParser_Next(t *testing.T) {
 		})
 	}
 }
+
+func leafOperand(id rasm.OperandID, tok rasm.Token) rasm.Operand {
+	return rasm.Operand{ID: id, Root: tok, Expr: &rasm.Node{Tok: tok}}
+}
+
+func tokenPtr(tok rasm.Token) *rasm.Token {
+	return &tok
+}
+
+func TestParserRecovery(t *testing.T) {
+	p := rasm.NewParser(strings.NewReader("mov eax, [rbx,, ecx]\nlabel\nmov eax, 1\n5 mov\nlabel:"))
+	want := []rasm.ExprID{
+		rasm.IllegalExpr,
+		rasm.IllegalExpr,
+		rasm.InstrExpr,
+		rasm.IllegalExpr,
+		rasm.LabelExpr,
+		rasm.EOFExpr,
+	}
+
+	for i, wantID := range want {
+		if got := p.Next(); got.ID != wantID {
+			t.Errorf("Next() #%d = %v, want expression of ID %v", i, got, wantID)
+		}
+	}
+}
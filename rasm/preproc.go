@@ -0,0 +1,440 @@
+package rasm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A macro represents a single-line macro, defined by the %define, or %idefine,
+// directive.
+type macro struct {
+	// params contains the names of the macro's parameters. It's nil for macros
+	// defined without a parameter list, which aren't invoked with arguments.
+	params []string
+	body   []Token
+	// expanding reports if the macro is being expanded, so it isn't expanded
+	// again inside its own expansion.
+	expanding bool
+}
+
+// A Preprocessor is an object which handles the preprocessor directives in the
+// [Token]s emitted by the [Lexer], and expands the macros in the rest of them,
+// before they're given to the [Parser].
+//
+// The tokens expanded from a single-line macro's body are positioned at its
+// invocation, so the errors in them point to the source which was actually
+// written. The tokens of the invocation's arguments keep their own positions.
+// The lines of a multi-line macro keep the positions of its definition, but
+// refer to the invocation they're expanded from.
+type Preprocessor struct {
+	lxr *Lexer // the lexer of the file being read
+	// macros contains the case-sensitive macros by their names, while imacros
+	// contains the case-insensitive ones by their lowercase names.
+	macros      map[string]*macro
+	imacros     map[string]*macro
+	multiMacros map[string]*multiMacro
+	line        []Token // the remaining tokens of the current line
+	// pending contains the lines expanded from multi-line macros, which are
+	// preprocessed before the rest of the source.
+	pending [][]Token
+	// expansions is the number of multi-line macro expansions so far, which
+	// makes the names of their local labels unique.
+	expansions int
+	conds      []condFrame // the conditional blocks the current line is in
+	// includes contains the files including the one being read, the innermost
+	// last, while once contains the absolute paths of the files which can't be
+	// included again.
+	includes     []includeFrame
+	includePaths []string
+	once         map[string]bool
+	// reps contains the %rep blocks being repeated, the innermost last, whose
+	// iterations are limited to repLimit.
+	reps     []repFrame
+	repLimit int64
+	// assigns contains the values of the %assign constants known while
+	// preprocessing, which can be used by the directives.
+	assigns map[string]int64
+}
+
+// NewPreprocessor creates a new preprocessor based on the given [Lexer].
+func NewPreprocessor(lxr *Lexer) *Preprocessor {
+	return &Preprocessor{
+		lxr:         lxr,
+		macros:      map[string]*macro{},
+		imacros:     map[string]*macro{},
+		multiMacros: map[string]*multiMacro{},
+		once:        map[string]bool{},
+		repLimit:    defaultRepLimit,
+		assigns:     map[string]int64{},
+	}
+}
+
+// Next returns the next token of the preprocessed source. If the file has been
+// fully read, Next will always return a token with the [EOF] [TokenID].
+//
+// The lines of preprocessor directives, and the ones skipped by conditional
+// assembly, are left empty. Malformed directives, and macro invocations, are
+// replaced by an [Illegal] token, whose raw string is the error's message.
+func (pp *Preprocessor) Next() Token {
+	for len(pp.line) == 0 {
+		pp.line = pp.preprocess(pp.readLine())
+	}
+
+	tok := pp.line[0]
+	if tok.ID() == EOF && len(pp.conds) != 0 {
+		pp.line = pp.unterminated(tok)
+		tok = pp.line[0]
+	}
+
+	pp.line = pp.line[1:]
+
+	return tok
+}
+
+// readLine reads the tokens of the next line, up to and including its newline.
+// The pending lines of macro expansions, and %rep blocks, are read first.
+func (pp *Preprocessor) readLine() []Token {
+	for len(pp.pending) != 0 {
+		line := pp.pending[0]
+		pp.pending = pp.pending[1:]
+
+		// An empty line ends the iteration of the innermost %rep block.
+		if len(line) == 0 {
+			pp.repeat()
+			continue
+		}
+
+		return line
+	}
+
+	line := []Token{}
+
+	// The lines of skipped branches aren't lexed past their first token, unless
+	// it's a conditional directive.
+	if pp.skipping() {
+		first := pp.lex()
+		if first.ID() == Newline || first.ID() == EOF {
+			return []Token{first}
+		} else if !isConditional(first) {
+			pp.lxr.skipLine()
+			return []Token{pp.lex()}
+		}
+
+		line = append(line, first)
+	}
+
+	for {
+		tok := pp.lex()
+		line = append(line, tok)
+
+		if tok.ID() == Newline || tok.ID() == EOF {
+			return line
+		}
+	}
+}
+
+func (pp *Preprocessor) preprocess(line []Token) []Token {
+	end := line[len(line)-1]
+
+	if isConditional(line[0]) {
+		return pp.conditional(line)
+	} else if pp.skipping() {
+		return []Token{end}
+	}
+
+	if first := line[0]; first.ID() == Directive {
+		switch DirectiveID(first.SpecID()) {
+		case DefineDir, IdefineDir, UndefDir:
+			if err := pp.define(line); err != nil {
+				return errorLine(err, end)
+			}
+
+			return []Token{end}
+		case MacroDir:
+			return pp.defineMulti(line)
+		case EndmacroDir:
+			return errorLine(tokenError(first, "%endmacro without %macro"), end)
+		case IncludeDir:
+			return pp.include(line)
+		case PragmaDir:
+			if err := pp.pragma(line); err != nil {
+				return errorLine(err, end)
+			}
+
+			return []Token{end}
+		case RepDir:
+			return pp.rep(line)
+		case EndrepDir:
+			return errorLine(tokenError(first, "%endrep without %rep"), end)
+		case ExitrepDir:
+			return pp.exitRep(line)
+		}
+	}
+
+	// Multi-line macros are invoked at the start of a line, which can also
+	// start with a label.
+	if m := pp.lookupMulti(line[0]); m != nil {
+		return pp.invoke(m, line)
+	} else if len(line) > 2 && line[1].ID() == Colon && pp.lookupMulti(line[2]) != nil {
+		return append(line[:2:2], pp.invoke(pp.lookupMulti(line[2]), line[2:])...)
+	}
+
+	expanded, err := pp.expand(line)
+	if err != nil {
+		return errorLine(err, end)
+	}
+
+	if first := expanded[0]; first.ID() == Directive && DirectiveID(first.SpecID()) == AssignDir {
+		pp.trackAssign(expanded)
+	}
+
+	return expanded
+}
+
+// define handles a %define, %idefine or %undef directive line.
+func (pp *Preprocessor) define(line []Token) *Error {
+	dir, name := line[0], line[1]
+	if !isName(name) {
+		return tokenError(name, "expected macro name, found "+describe(name))
+	}
+
+	if DirectiveID(dir.SpecID()) == UndefDir {
+		if err := expectEnd(line[2]); err != nil {
+			return err
+		}
+
+		delete(pp.macros, name.Raw())
+		delete(pp.imacros, strings.ToLower(name.Raw()))
+
+		return nil
+	}
+
+	m := &macro{}
+	rest := line[2:]
+
+	// Only a parenthesis right after the name starts the parameter list.
+	if lparen := rest[0]; lparen.ID() == LParen && lparen.Pos() == tokenEnd(name) {
+		var err *Error
+		if m.params, rest, err = parseParams(rest); err != nil {
+			return err
+		}
+	}
+
+	m.body = rest[:len(rest)-1]
+
+	if DirectiveID(dir.SpecID()) == IdefineDir {
+		pp.imacros[strings.ToLower(name.Raw())] = m
+	} else {
+		pp.macros[name.Raw()] = m
+	}
+
+	return nil
+}
+
+// parseParams parses the parameter list of a macro, starting with its opening
+// parenthesis. It returns the names of the parameters, and the tokens after
+// the list.
+func parseParams(toks []Token) ([]string, []Token, *Error) {
+	params := []string{}
+	if toks[1].ID() == RParen {
+		return params, toks[2:], nil
+	}
+
+	for i := 1; ; i += 2 {
+		param := toks[i]
+		if !isName(param) {
+			return nil, nil, tokenError(param, "expected parameter name, found "+describe(param))
+		} else if slices.Contains(params, param.Raw()) {
+			return nil, nil, tokenError(param, fmt.Sprintf("duplicate parameter '%s'", param.Raw()))
+		}
+
+		params = append(params, param.Raw())
+
+		switch delim := toks[i+1]; delim.ID() {
+		case RParen:
+			return params, toks[i+2:], nil
+		case Comma:
+		default:
+			return nil, nil, tokenError(delim, "expected ',' or ')', found "+describe(delim))
+		}
+	}
+}
+
+// expand expands the macros invoked in the tokens. The expansion of a macro is
+// expanded again, except for the macro itself, so it can't recurse forever.
+func (pp *Preprocessor) expand(toks []Token) ([]Token, *Error) {
+	expanded := make([]Token, 0, len(toks))
+
+	for i := 0; i < len(toks); i++ {
+		name := toks[i]
+
+		m := pp.lookup(name)
+		if m == nil || m.expanding {
+			expanded = append(expanded, name)
+			continue
+		}
+
+		// Macros with parameters are only invoked if they're given arguments.
+		hasArgs := i+1 < len(toks) && toks[i+1].ID() == LParen
+		if m.params != nil && !hasArgs {
+			expanded = append(expanded, name)
+			continue
+		}
+
+		var args [][]Token
+		end := tokenEnd(name)
+
+		if m.params != nil {
+			var n int
+			var err *Error
+			if args, n, err = splitArgs(toks[i+1:]); err != nil {
+				return nil, err
+			}
+
+			end = tokenEnd(toks[i+n])
+			i += n
+
+			if len(args) != len(m.params) {
+				return nil, &Error{
+					Kind: SyntaxError,
+					File: name.File(),
+					Pos:  name.Pos(),
+					End:  end,
+					Msg: fmt.Sprintf(
+						"macro '%s' expects %s, but got %d",
+						name.Raw(), plural(len(m.params), "argument"), len(args),
+					),
+				}
+			}
+		}
+
+		m.expanding = true
+		body, err := pp.expand(m.substitute(args, name, end))
+		m.expanding = false
+
+		if err != nil {
+			return nil, err
+		}
+
+		expanded = append(expanded, body...)
+	}
+
+	return expanded, nil
+}
+
+// lookup returns the macro with the token's name, or nil if there's none.
+// Case-sensitive macros are preferred over case-insensitive ones.
+func (pp *Preprocessor) lookup(tok Token) *macro {
+	if !isName(tok) {
+		return nil
+	}
+
+	if m, ok := pp.macros[tok.Raw()]; ok {
+		return m
+	}
+
+	return pp.imacros[strings.ToLower(tok.Raw())]
+}
+
+// splitArgs splits the arguments of a macro invocation, starting with its
+// opening parenthesis, at the commas which aren't nested in parentheses, or
+// brackets. It also returns the number of tokens up to the closing
+// parenthesis, including it.
+func splitArgs(toks []Token) ([][]Token, int, *Error) {
+	args := [][]Token{}
+	arg := []Token{}
+	depth := 0
+
+	for i := 1; i < len(toks); i++ {
+		tok := toks[i]
+
+		switch tok.ID() {
+		case LParen, LBracket:
+			depth++
+		case RBracket:
+			depth--
+		case RParen:
+			if depth == 0 {
+				if len(args) != 0 || len(arg) != 0 {
+					args = append(args, arg)
+				}
+
+				return args, i + 1, nil
+			}
+
+			depth--
+		case Comma:
+			if depth == 0 {
+				args, arg = append(args, arg), []Token{}
+				continue
+			}
+		case Newline, EOF:
+			return nil, 0, tokenError(tok, "expected ')', found "+describe(tok))
+		}
+
+		arg = append(arg, tok)
+	}
+
+	return nil, 0, tokenError(toks[len(toks)-1], "expected ')'")
+}
+
+// substitute returns the body of the macro, with its parameters replaced by
+// the arguments. The tokens of the body are positioned at the invocation,
+// which ends at the given position.
+func (m *macro) substitute(args [][]Token, name Token, end Position) []Token {
+	toks := make([]Token, 0, len(m.body))
+
+	for _, tok := range m.body {
+		if ix := slices.Index(m.params, tok.Raw()); ix != -1 && isName(tok) {
+			toks = append(toks, args[ix]...)
+			continue
+		}
+
+		tok.pos, tok.file, tok.end, tok.exp = name.pos, name.file, end, name.exp
+		toks = append(toks, tok)
+	}
+
+	return toks
+}
+
+// isName reports if the token can be the name of a macro, or its parameter.
+func isName(tok Token) bool {
+	switch tok.ID() {
+	case Identifier, Instruction, Register, Size:
+		return true
+	}
+
+	return false
+}
+
+func tokenError(tok Token, msg string) *Error {
+	return &Error{Kind: SyntaxError, File: tok.File(), Pos: tok.Pos(), End: tokenEnd(tok), Msg: msg}
+}
+
+// unterminated returns the tokens emitted at the end of the file, instead of
+// the given EOF token, if it's in conditional blocks. Each block is reported at
+// its opening directive, on a line of its own.
+func (pp *Preprocessor) unterminated(eof Token) []Token {
+	line := []Token{{pos: eof.pos, file: eof.file, id: Newline, raw: "\\n"}}
+
+	for i, frame := range pp.conds {
+		end := Token{pos: eof.pos, file: eof.file, id: Newline, raw: "\\n"}
+		if i == len(pp.conds)-1 {
+			end = eof
+		}
+
+		msg := strings.ToLower(frame.dir.Raw()) + " without %endif"
+		line = append(line, errorLine(tokenError(frame.dir, msg), end)...)
+	}
+
+	pp.conds = nil
+
+	return line
+}
+
+// errorLine returns a line made of an illegal token carrying the error's
+// message, which is reported by the [Parser], and the line's end.
+func errorLine(err *Error, end Token) []Token {
+	return []Token{{pos: err.Pos, file: err.File, id: Illegal, raw: err.Msg, end: err.End}, end}
+}
@@ -0,0 +1,622 @@
+package rasm_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nilhiu/rei/rasm"
+	"github.com/nilhiu/rei/x86"
+)
+
+func TestPreprocessor_Next(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []rasm.Token
+	}{
+		{
+			name: "Should expand macro",
+			src:  "%define SIZE 4\nmov eax, SIZE",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 14}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Instruction|rasm.TokenID(x86.MOV), "mov"),
+				rasm.NewToken(rasm.Position{2, 4}, rasm.Register|rasm.TokenID(x86.EAX), "eax"),
+				rasm.NewToken(rasm.Position{2, 7}, rasm.Comma, ","),
+				rasm.NewToken(rasm.Position{2, 9}, rasm.Decimal, "4"),
+			},
+		},
+		{
+			name: "Should expand macro with parameters",
+			src:  "%define ARG(n) [rbp+8*n]\nARG(2)",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 24}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.LBracket, "["),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Register|rasm.TokenID(x86.RBP), "rbp"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Operator, "+"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Decimal, "8"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Operator, "*"),
+				rasm.NewToken(rasm.Position{2, 4}, rasm.Decimal, "2"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.RBracket, "]"),
+			},
+		},
+		{
+			name: "Should split arguments outside of parentheses",
+			src:  "%define SUM(a, b) a+b\nSUM((1, 2), 3)",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 21}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 4}, rasm.LParen, "("),
+				rasm.NewToken(rasm.Position{2, 5}, rasm.Decimal, "1"),
+				rasm.NewToken(rasm.Position{2, 6}, rasm.Comma, ","),
+				rasm.NewToken(rasm.Position{2, 8}, rasm.Decimal, "2"),
+				rasm.NewToken(rasm.Position{2, 9}, rasm.RParen, ")"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Operator, "+"),
+				rasm.NewToken(rasm.Position{2, 12}, rasm.Decimal, "3"),
+			},
+		},
+		{
+			name: "Should not expand macro with parameters without arguments",
+			src:  "%define F(a) a\nF",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 14}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Identifier, "F"),
+			},
+		},
+		{
+			name: "Should not start parameters after space",
+			src:  "%define F (1)\nF",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 13}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.LParen, "("),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Decimal, "1"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.RParen, ")"),
+			},
+		},
+		{
+			name: "Should expand case-insensitive macro",
+			src:  "%idefine Foo 1\nfoo FOO",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 14}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Decimal, "1"),
+				rasm.NewToken(rasm.Position{2, 4}, rasm.Decimal, "1"),
+			},
+		},
+		{
+			name: "Should not expand case-sensitive macro in other case",
+			src:  "%define Foo 1\nfoo",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 13}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Identifier, "foo"),
+			},
+		},
+		{
+			name: "Should not expand undefined macro",
+			src:  "%idefine X 1\n%undef x\nX",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 12}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 8}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{3, 0}, rasm.Identifier, "X"),
+			},
+		},
+		{
+			name: "Should expand macros in expansion",
+			src:  "%define A B+B\n%define B 2\nA",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 13}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 11}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{3, 0}, rasm.Decimal, "2"),
+				rasm.NewToken(rasm.Position{3, 0}, rasm.Operator, "+"),
+				rasm.NewToken(rasm.Position{3, 0}, rasm.Decimal, "2"),
+			},
+		},
+		{
+			name: "Should not expand macro in its own expansion",
+			src:  "%define X X+1\nX",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 13}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Identifier, "X"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Operator, "+"),
+				rasm.NewToken(rasm.Position{2, 0}, rasm.Decimal, "1"),
+			},
+		},
+		{
+			name: "Should not define macro without name",
+			src:  "%define 5 1",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 8}, rasm.Illegal, "expected macro name, found '5'"),
+			},
+		},
+		{
+			name: "Should not define macro with malformed parameters",
+			src:  "%define F(a b) a",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 12}, rasm.Illegal, "expected ',' or ')', found 'b'"),
+			},
+		},
+		{
+			name: "Should not expand macro with wrong argument count",
+			src:  "%define F(a) a\nmov eax, F(1, 2)",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 14}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 9}, rasm.Illegal, "macro 'F' expects 1 argument, but got 2"),
+			},
+		},
+		{
+			name: "Should not expand macro with unclosed arguments",
+			src:  "%define F(a) a\nF(1",
+			want: []rasm.Token{
+				rasm.NewToken(rasm.Position{1, 14}, rasm.Newline, "\\n"),
+				rasm.NewToken(rasm.Position{2, 3}, rasm.Illegal, "expected ')', found end of file"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := rasm.NewPreprocessor(rasm.NewLexer(strings.NewReader(tt.src)))
+
+			for i, want := range tt.want {
+				got := pp.Next()
+				same := got.Pos() == want.Pos() && got.ID() == want.ID() &&
+					got.SpecID() == want.SpecID() && got.Raw() == want.Raw()
+
+				if !same {
+					t.Fatalf("Next() #%d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPreprocessorMacros(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "Should expand macro with parameters",
+			src:  "%macro m 2\nmov %1, %2\n%endmacro\nm eax, [rbx + 4]",
+			want: "mov eax , [ rbx + 4 ]",
+		},
+		{
+			name: "Should expand macro with default arguments",
+			src:  "%macro m 1-3 5\nmov %1, %2 %3\n%endmacro\nm eax\nm ebx, 6",
+			want: "mov eax , 5\nmov ebx , 6",
+		},
+		{
+			name: "Should expand greedy macro",
+			src:  "%macro m 1+\ndb %1\n%endmacro\nm 1, (2, 3), 4",
+			want: "db 1 , ( 2 , 3 ) , 4",
+		},
+		{
+			name: "Should expand argument count",
+			src:  "%macro m 0-*\ndd %0\n%endmacro\nm\nm a, b",
+			want: "dd 0\ndd 2",
+		},
+		{
+			name: "Should give local labels unique names",
+			src:  "%macro m 0\n%%l: jmp %%l\n%endmacro\nm\nm",
+			want: "..@1.l : jmp ..@1.l\n..@2.l : jmp ..@2.l",
+		},
+		{
+			name: "Should expand macro after label",
+			src:  "%macro m 0\nmov eax, 1\n%endmacro\nx: m",
+			want: "x : mov eax , 1",
+		},
+		{
+			name: "Should expand macros in expansion",
+			src:  "%define V 7\n%macro a 1\nmov eax, %1\n%endmacro\n%macro b 0\na V\n%endmacro\nb",
+			want: "mov eax , 7",
+		},
+		{
+			name: "Should not expand macro with wrong argument count",
+			src:  "%macro m 2\n%endmacro\nm 1",
+			want: "error: macro 'm' expects 2 arguments, but got 1",
+		},
+		{
+			name: "Should not expand macro with argument count out of range",
+			src:  "%macro m 1-3\n%endmacro\nm",
+			want: "error: macro 'm' expects 1 to 3 arguments, but got 0",
+		},
+		{
+			name: "Should not expand recursive macro forever",
+			src:  "%macro m 0\nm\n%endmacro\nm",
+			want: "error: macro expansions are nested too deeply",
+		},
+		{
+			name: "Should not define macro without parameter count",
+			src:  "%macro m x\n%endmacro\nmov eax, 1",
+			want: "error: expected parameter count, found 'x'\nmov eax , 1",
+		},
+		{
+			name: "Should not define unterminated macro",
+			src:  "%macro m 0\nmov eax, 1",
+			want: "error: %macro without %endmacro",
+		},
+		{
+			name: "Should not end macro without its start",
+			src:  "%endmacro",
+			want: "error: %endmacro without %macro",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := rasm.NewPreprocessor(rasm.NewLexer(strings.NewReader(tt.src)))
+			if got := render(pp); got != tt.want {
+				t.Errorf("Next() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreprocessorConditionals(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "Should assemble true branch",
+			src:  "%if 2 > 1\nmov eax, 1\n%else\nmov eax, 2\n%endif",
+			want: "mov eax , 1",
+		},
+		{
+			name: "Should assemble else branch",
+			src:  "%if 1 == 2 || !1\nmov eax, 1\n%else\nmov eax, 2\n%endif",
+			want: "mov eax , 2",
+		},
+		{
+			name: "Should assemble first true elif branch",
+			src:  "%if 0\nmov eax, 1\n%elif 1\nmov eax, 2\n%elif 1\nmov eax, 3\n%else\nmov eax, 4\n%endif",
+			want: "mov eax , 2",
+		},
+		{
+			name: "Should expand macros in condition",
+			src:  "%define LEVEL 3\n%if LEVEL >= 2 && LEVEL < 4\nmov eax, LEVEL\n%endif",
+			want: "mov eax , 3",
+		},
+		{
+			name: "Should test defined macros",
+			src:  "%define DEBUG\n%ifdef DEBUG\nmov eax, 1\n%endif\n%ifndef DEBUG\nmov eax, 2\n%endif",
+			want: "mov eax , 1",
+		},
+		{
+			name: "Should test undefined macros with elifdef",
+			src:  "%ifdef A\nmov eax, 1\n%elifndef B\nmov eax, 2\n%endif",
+			want: "mov eax , 2",
+		},
+		{
+			name: "Should test identical texts",
+			src:  "%define REG eax\n%ifidn REG, eax\nmov eax, 1\n%endif\n%ifidn REG, EAX\nmov eax, 2\n%endif",
+			want: "mov eax , 1",
+		},
+		{
+			name: "Should test identical numbers by their bases",
+			src:  "%ifidn 0x10, 10\nmov eax, 1\n%endif\n%ifidn 0x10, 0x10\nmov eax, 2\n%endif",
+			want: "mov eax , 2",
+		},
+		{
+			name: "Should test identical texts ignoring case",
+			src:  "%ifidni [rax + 1], [RAX + 1]\nmov eax, 1\n%endif",
+			want: "mov eax , 1",
+		},
+		{
+			name: "Should test multi-line macros",
+			src:  "%macro m 0\n%endmacro\n%ifmacro m\nmov eax, 1\n%endif\n%ifmacro n\nmov eax, 2\n%endif",
+			want: "mov eax , 1",
+		},
+		{
+			name: "Should test arguments in macro expansion",
+			src:  "%macro load 1\n%ifidn %1, 0\nxor eax, eax\n%else\nmov eax, %1\n%endif\n%endmacro\nload 0\nload 5",
+			want: "xor eax , eax\nmov eax , 5",
+		},
+		{
+			name: "Should skip blocks nested in skipped branch",
+			src:  "%if 0\n%if 1\nmov eax, 1\n%else\nmov eax, 2\n%endif\n%else\nmov eax, 3\n%endif",
+			want: "mov eax , 3",
+		},
+		{
+			name: "Should not lex skipped lines",
+			src:  "%if 0\n'unterminated\n%error here\n#@!\n%define X 1\n%endif\nmov eax, X",
+			want: "mov eax , X",
+		},
+		{
+			name: "Should not assemble any branch after malformed condition",
+			src:  "%if 1 +\nmov eax, 1\n%else\nmov eax, 2\n%endif",
+			want: "error: expected register or number, found end of line",
+		},
+		{
+			name: "Should not compare labels",
+			src:  "%if start\n%endif",
+			want: "error: expected constant, found 'start'",
+		},
+		{
+			name: "Should not test identical texts without comma",
+			src:  "%ifidn a\n%endif",
+			want: "error: %ifidn expects two texts separated by a comma",
+		},
+		{
+			name: "Should not accept text after endif",
+			src:  "%if 1\n%endif 1",
+			want: "error: expected end of line, found '1'",
+		},
+		{
+			name: "Should not end block without its start",
+			src:  "%endif",
+			want: "error: %endif without %if",
+		},
+		{
+			name: "Should not accept elif after else",
+			src:  "%if 0\n%else\n%elif 1\n%endif",
+			want: "error: %elif after %else",
+		},
+		{
+			name: "Should report unterminated blocks",
+			src:  "%if 1\nmov eax, 1\n%IFDEF X",
+			want: "mov eax , 1\nerror: %if without %endif\nerror: %ifdef without %endif",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := rasm.NewPreprocessor(rasm.NewLexer(strings.NewReader(tt.src)))
+			if got := render(pp); got != tt.want {
+				t.Errorf("Next() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreprocessorReps(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "Should repeat lines",
+			src:  "%rep 3\nmov eax, 1\n%endrep\nmov ecx, 2",
+			want: "mov eax , 1\nmov eax , 1\nmov eax , 1\nmov ecx , 2",
+		},
+		{
+			name: "Should not repeat lines zero times",
+			src:  "%rep 0\nmov eax, 1\n%endrep",
+			want: "",
+		},
+		{
+			name: "Should repeat nested blocks",
+			src:  "%rep 2\n%rep 2\nmov eax, 1\n%endrep\nmov ecx, 2\n%endrep",
+			want: "mov eax , 1\nmov eax , 1\nmov ecx , 2\nmov eax , 1\nmov eax , 1\nmov ecx , 2",
+		},
+		{
+			name: "Should use assigned constants in count and conditions",
+			src:  "%assign n 2 * 2\n%assign i 0\n%rep n\n%if i % 2\ndb i\n%endif\n%assign i i + 1\n%endrep",
+			want: "%assign n 2 * 2\n%assign i 0\n%assign i i + 1\ndb i\n%assign i i + 1\n%assign i i + 1\ndb i\n%assign i i + 1",
+		},
+		{
+			name: "Should exit block early",
+			src:  "%assign i 0\n%rep 100\n%if i == 2\n%exitrep\n%endif\n%assign i i + 1\n%endrep\n%if 1\nmov eax, i\n%endif",
+			want: "%assign i 0\n%assign i i + 1\n%assign i i + 1\nmov eax , i",
+		},
+		{
+			name: "Should exit only innermost block",
+			src:  "%rep 2\n%rep 5\nmov eax, 1\n%exitrep\n%endrep\nmov ecx, 2\n%endrep",
+			want: "mov eax , 1\nmov ecx , 2\nmov eax , 1\nmov ecx , 2",
+		},
+		{
+			name: "Should repeat macro invocations",
+			src:  "%macro m 1\nmov eax, %1\n%endmacro\n%rep 2\nm 4\n%endrep",
+			want: "mov eax , 4\nmov eax , 4",
+		},
+		{
+			name: "Should repeat block at end of file",
+			src:  "%rep 2\nmov eax, 1\n%endrep",
+			want: "mov eax , 1\nmov eax , 1",
+		},
+		{
+			name: "Should not repeat more than limit",
+			src:  "%rep 2000000\nmov eax, 1\n%endrep",
+			want: "error: %rep count 2000000 exceeds the limit of 1000000 iterations",
+		},
+		{
+			name: "Should not repeat negative times",
+			src:  "%rep 1 - 2\nmov eax, 1\n%endrep",
+			want: "error: %rep count cannot be negative",
+		},
+		{
+			name: "Should not repeat with unknown count",
+			src:  "%rep n\nmov eax, 1\n%endrep",
+			want: "error: expected constant, found 'n'",
+		},
+		{
+			name: "Should not repeat unterminated block",
+			src:  "%rep 2\nmov eax, 1",
+			want: "error: %rep without %endrep",
+		},
+		{
+			name: "Should not end block without its start",
+			src:  "%endrep",
+			want: "error: %endrep without %rep",
+		},
+		{
+			name: "Should not exit without block",
+			src:  "%exitrep",
+			want: "error: %exitrep without %rep",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := rasm.NewPreprocessor(rasm.NewLexer(strings.NewReader(tt.src)))
+			if got := render(pp); got != tt.want {
+				t.Errorf("Next() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreprocessorRepLimit(t *testing.T) {
+	pp := rasm.NewPreprocessor(rasm.NewLexer(strings.NewReader("%rep 3\nmov eax, 1\n%endrep\n%rep 2\n%endrep")))
+	pp.SetRepLimit(2)
+
+	want := "error: %rep count 3 exceeds the limit of 2 iterations"
+	if got := render(pp); got != want {
+		t.Errorf("Next() = %q, want %q", got, want)
+	}
+}
+
+func TestPreprocessorIncludes(t *testing.T) {
+	files := map[string]string{
+		"inc/consts.inc": "%define ONE 1\n%include \"more.inc\"\n",
+		"inc/more.inc":   "mov eax, ONE",
+		"lib/once.inc":   "%pragma once\nmov ebx, 2\n",
+		"lib/self.inc":   "%include \"self.inc\"\n",
+		"lib/open.inc":   "%macro m 0\nmov eax, 1\n",
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "Should include files relative to including file",
+			src:  "%include \"inc/consts.inc\"\nmov ecx, ONE",
+			want: "mov eax , 1\nmov ecx , 1",
+		},
+		{
+			name: "Should include files from include paths",
+			src:  "%include \"once.inc\"\nmov ecx, 3",
+			want: "mov ebx , 2\nmov ecx , 3",
+		},
+		{
+			name: "Should include file at end of file",
+			src:  "mov ecx, 3\n%include \"once.inc\"",
+			want: "mov ecx , 3\nmov ebx , 2",
+		},
+		{
+			name: "Should include file with once pragma only once",
+			src:  "%include \"once.inc\"\n%include \"once.inc\"",
+			want: "mov ebx , 2",
+		},
+		{
+			name: "Should include files in macro expansions in place",
+			src:  "%macro m 0\n%include \"once.inc\"\nmov ecx, 3\n%endmacro\nm",
+			want: "mov ebx , 2\nmov ecx , 3",
+		},
+		{
+			name: "Should expand macros in file name",
+			src:  "%define FILE \"once.inc\"\n%include FILE",
+			want: "mov ebx , 2",
+		},
+		{
+			name: "Should not include file recursively",
+			src:  "%include \"self.inc\"",
+			want: "error: 'DIR/lib/self.inc' is included recursively",
+		},
+		{
+			name: "Should not include missing file",
+			src:  "%include \"missing.inc\"\nmov ecx, 3",
+			want: "error: cannot find included file 'missing.inc'\nmov ecx , 3",
+		},
+		{
+			name: "Should not include without file name",
+			src:  "%include once.inc",
+			want: "error: expected file name, found 'once.inc'",
+		},
+		{
+			name: "Should not end macro in another file",
+			src:  "%include \"open.inc\"\n%endmacro",
+			want: "error: %macro without %endmacro\nerror: %endmacro without %macro",
+		},
+		{
+			name: "Should not accept unknown pragma",
+			src:  "%pragma twice",
+			want: "error: unknown pragma 'twice'",
+		},
+	}
+
+	dir := t.TempDir()
+	for name, src := range files {
+		writeFile(t, filepath.Join(dir, name), src)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lxr := rasm.NewLexerFile(filepath.Join(dir, "main.asm"), strings.NewReader(tt.src))
+			pp := rasm.NewPreprocessor(lxr)
+			pp.SetIncludePaths([]string{filepath.Join(dir, "lib")})
+
+			want := strings.ReplaceAll(tt.want, "DIR", dir)
+			if got := render(pp); got != want {
+				t.Errorf("Next() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestPreprocessorIncludePositions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.inc")
+	writeFile(t, path, "\n  mov eax, 1")
+
+	src := "%include \"" + path + "\"\nmov ecx, 2\n"
+	pp := rasm.NewPreprocessor(rasm.NewLexerFile("main.asm", strings.NewReader(src)))
+
+	want := []string{path + ":2:2", "main.asm:2:0"}
+	got := []string{}
+
+	for tok := pp.Next(); tok.ID() != rasm.EOF; tok = pp.Next() {
+		if tok.ID() == rasm.Instruction {
+			got = append(got, fmt.Sprintf("%s:%d:%d", tok.File(), tok.Pos().Line, tok.Pos().Col))
+		}
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("Next() positions = %q, want %q", got, want)
+	}
+}
+
+func writeFile(t *testing.T, path, src string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// render returns the non-empty lines of the preprocessed source, whose tokens
+// are separated by spaces. Illegal tokens are rendered as errors.
+func render(pp *rasm.Preprocessor) string {
+	lines := []string{}
+	line := []string{}
+
+	for {
+		tok := pp.Next()
+
+		switch tok.ID() {
+		case rasm.Newline, rasm.EOF:
+			if len(line) != 0 {
+				lines = append(lines, strings.Join(line, " "))
+			}
+
+			if tok.ID() == rasm.EOF {
+				return strings.Join(lines, "\n")
+			}
+
+			line = []string{}
+		case rasm.Illegal:
+			line = append(line, "error: "+tok.Raw())
+		default:
+			line = append(line, tok.Raw())
+		}
+	}
+}
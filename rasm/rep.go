@@ -0,0 +1,137 @@
+package rasm
+
+import (
+	"fmt"
+	"slices"
+)
+
+// defaultRepLimit is the default maximum count of a %rep block's iterations,
+// which stops a mistaken count from expanding for too long.
+const defaultRepLimit = 1_000_000
+
+// A repFrame represents a %rep block, whose lines are being repeated.
+type repFrame struct {
+	body [][]Token
+	left int64 // the number of iterations left after the current one
+	end  Token // the end of the %endrep line, emitted after the last iteration
+	// conds is the number of conditional blocks the %rep block is in, so the
+	// ones left open by %exitrep are ended along with the block.
+	conds int
+}
+
+// SetRepLimit sets the maximum count of a %rep block's iterations, which also
+// limits the count of the copies made by the times prefix.
+func (pp *Preprocessor) SetRepLimit(limit int64) {
+	pp.repLimit = limit
+}
+
+// rep handles a %rep directive line, reading the block's lines up to its
+// %endrep. The iterations are preprocessed one by one, so the directives in
+// them, like %assign, are handled before the next iteration.
+func (pp *Preprocessor) rep(line []Token) []Token {
+	body, end, bodyErr := pp.readBody(line[0], EndrepDir)
+
+	count, err := pp.repCount(line)
+	if err != nil {
+		return errorLine(err, end)
+	} else if bodyErr != nil {
+		return errorLine(bodyErr, end)
+	}
+
+	if count == 0 || len(body) == 0 {
+		return []Token{end}
+	}
+
+	pp.reps = append(pp.reps, repFrame{body: body, left: count, end: end, conds: len(pp.conds)})
+	pp.repeat()
+
+	return nil
+}
+
+// repCount evaluates the count of a %rep block, given by its directive line.
+func (pp *Preprocessor) repCount(line []Token) (int64, *Error) {
+	toks, err := pp.expand(line[1 : len(line)-1])
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := pp.evalExpr(toks, line[len(line)-1])
+	if err != nil {
+		return 0, err
+	}
+
+	var msg string
+	switch {
+	case count < 0:
+		msg = "%rep count cannot be negative"
+	case count > pp.repLimit:
+		msg = fmt.Sprintf("%%rep count %d exceeds the limit of %d iterations", count, pp.repLimit)
+	default:
+		return count, nil
+	}
+
+	return 0, &Error{Kind: SyntaxError, File: line[1].File(), Pos: line[1].Pos(), End: tokenEnd(line[len(line)-2]), Msg: msg}
+}
+
+// repeat starts the next iteration of the innermost %rep block, whose lines
+// are followed by an empty line, which ends it. After the last iteration, the
+// block is ended by the end of its %endrep line.
+func (pp *Preprocessor) repeat() {
+	frame := &pp.reps[len(pp.reps)-1]
+	if frame.left == 0 {
+		pp.reps = pp.reps[:len(pp.reps)-1]
+		pp.pending = append([][]Token{{frame.end}}, pp.pending...)
+
+		return
+	}
+
+	frame.left--
+
+	lines := make([][]Token, 0, len(frame.body)+len(pp.pending)+1)
+	lines = append(append(lines, frame.body...), nil)
+	pp.pending = append(lines, pp.pending...)
+}
+
+// exitRep handles an %exitrep directive line, which ends the innermost %rep
+// block, skipping the rest of its current iteration.
+func (pp *Preprocessor) exitRep(line []Token) []Token {
+	dir, end := line[0], line[len(line)-1]
+
+	if len(pp.reps) == 0 {
+		return errorLine(tokenError(dir, "%exitrep without %rep"), end)
+	} else if err := expectEnd(line[1]); err != nil {
+		return errorLine(err, end)
+	}
+
+	// The iterations of the files included in the block can't be ended.
+	ix := slices.IndexFunc(pp.pending, func(line []Token) bool { return len(line) == 0 })
+	if ix == -1 {
+		return errorLine(tokenError(dir, "%exitrep cannot end %rep block outside of the included file"), end)
+	}
+
+	frame := &pp.reps[len(pp.reps)-1]
+	frame.left = 0
+
+	pp.pending = pp.pending[ix:]
+	pp.conds = pp.conds[:frame.conds]
+
+	return []Token{end}
+}
+
+// trackAssign records the value of the constant assigned by an %assign
+// directive line, if it's known while preprocessing. The directive itself is
+// handled by the [CodeGen].
+func (pp *Preprocessor) trackAssign(line []Token) {
+	name := line[1]
+	if name.ID() != Identifier {
+		return
+	}
+
+	n, err := pp.evalExpr(line[2:len(line)-1], line[len(line)-1])
+	if err != nil {
+		delete(pp.assigns, name.Raw())
+		return
+	}
+
+	pp.assigns[name.Raw()] = n
+}
@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"debug/elf"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"slices"
 	"strings"
@@ -19,6 +20,7 @@ const (
 	Header64Size  = 64 // the size of an ELF header (64-bit)
 	Section64Size = 64 // the size of an ELF section header (64-bit)
 	Symbol64Size  = 24 // the size of an ELF symbol (64-bit)
+	Rela64Size    = 24 // the size of an ELF relocation with an addend (64-bit)
 )
 
 // Writer implements an ELF file writer.
@@ -30,8 +32,12 @@ type Writer struct {
 	shstrtab strings.Builder
 
 	symbols []elf.Sym64
+	symOff  map[string]uint32 // maps symbol names to their string table offsets
 	strtab  strings.Builder
 
+	relocs     map[string][]Relocation64
+	relocSects []string // the sections with relocations, in the order of writing
+
 	code   bytes.Buffer
 	output io.Writer
 }
@@ -55,6 +61,7 @@ type Section64 struct {
 	Addralign uint64          // the required alignment of the section
 	Entsize   uint64          // the size, in bytes, of each entry in the section
 	Code      []byte          // is the code/bytes associated with the section
+	Size      uint64          // the size of a [elf.SHT_NOBITS] section, which has no code
 }
 
 // Symbol64 represents an ELF symbol.
@@ -66,6 +73,16 @@ type Symbol64 struct {
 	Value uint64
 }
 
+// Relocation64 represents an ELF relocation with an addend. It refers to the
+// named symbol, or to the named section's symbol if it's given no symbol.
+type Relocation64 struct {
+	Offset  uint64 // the offset of the relocated field from the section's start
+	Symbol  string // the name of the symbol the relocation refers to
+	Section string // the name of the section the relocation refers to, if it has no symbol
+	Type    uint32 // the machine-specific type, such as [elf.R_X86_64_PC32]
+	Addend  int64  // the constant added to the symbol's value
+}
+
 // New returns a new [Writer] to write an ELF file to the given writer.
 // The filename is the source assembly file's name. It's needed as it has
 // to be encoded into the symbol table.
@@ -96,6 +113,8 @@ func New(filename string, hdr Header64, writer io.Writer) *Writer {
 		},
 		sections: []elf.Section64{{}},
 		symbols:  []elf.Sym64{{}},
+		symOff:   map[string]uint32{},
+		relocs:   map[string][]Relocation64{},
 		shndx:    map[string]uint16{"": 0},
 		shstrtab: strings.Builder{},
 		output:   writer,
@@ -122,15 +141,30 @@ func New(filename string, hdr Header64, writer io.Writer) *Writer {
 	return &w
 }
 
-// WriteSection writes the given section internally in the [Writer].
+// WriteSection writes the given section internally in the [Writer]. Sections
+// of type [elf.SHT_NOBITS] take up no space in the file, so their code is
+// ignored, and their size is given instead.
 func (w *Writer) WriteSection(sect Section64) error {
+	size := uint64(len(sect.Code))
+	if sect.Type == elf.SHT_NOBITS {
+		size = sect.Size
+		sect.Code = nil
+	}
+
+	// The code is placed after the header and the section headers, which take
+	// up a multiple of 64 bytes, so aligning its offset in the code is enough.
+	if align := sect.Addralign; align > 1 {
+		pad := (align - uint64(w.code.Len())%align) % align
+		w.code.Write(make([]byte, pad))
+	}
+
 	w.sections = append(w.sections, elf.Section64{
 		Name:      uint32(w.shstrtab.Len()),
 		Type:      uint32(sect.Type),
 		Flags:     uint64(sect.Flags),
 		Addr:      sect.Addr,
 		Off:       uint64(w.code.Len()),
-		Size:      uint64(len(sect.Code)),
+		Size:      size,
 		Link:      sect.Link,
 		Info:      sect.Info,
 		Addralign: sect.Addralign,
@@ -151,14 +185,12 @@ func (w *Writer) WriteSection(sect Section64) error {
 
 	w.header.Shnum++
 
-	if _, err := w.code.Write(sect.Code); err != nil {
-		return err
+	if sect.Type == elf.SHT_NOBITS {
+		return writeNullStr(&w.shstrtab, sect.Name)
 	}
 
-	pad := int(sect.Addralign) - (len(sect.Code) % int(sect.Addralign))
-	w.code.Grow(pad)
-	for i := pad; i > 0; i-- {
-		w.code.WriteByte(0)
+	if _, err := w.code.Write(sect.Code); err != nil {
+		return err
 	}
 
 	return writeNullStr(&w.shstrtab, sect.Name)
@@ -166,6 +198,10 @@ func (w *Writer) WriteSection(sect Section64) error {
 
 // WriteSymbol writes the given symbol internally in the [Writer].
 func (w *Writer) WriteSymbol(symb Symbol64) error {
+	if symb.Name != "" {
+		w.symOff[symb.Name] = uint32(w.strtab.Len())
+	}
+
 	w.symbols = append(w.symbols, elf.Sym64{
 		Name:  uint32(w.strtab.Len()),
 		Info:  byte(symb.Bind<<4) | byte(symb.Type),
@@ -177,10 +213,26 @@ func (w *Writer) WriteSymbol(symb Symbol64) error {
 	return writeNullStr(&w.strtab, symb.Name)
 }
 
+// WriteRelocation writes the given relocation of the named section internally
+// in the [Writer]. The relocations are written to a ".rela" prefixed section,
+// such as ".rela.text", when the [Writer] is flushed.
+func (w *Writer) WriteRelocation(section string, rel Relocation64) error {
+	if _, ok := w.relocs[section]; !ok {
+		w.relocSects = append(w.relocSects, section)
+	}
+
+	w.relocs[section] = append(w.relocs[section], rel)
+
+	return nil
+}
+
 // Flush compiles the written ELF file in the [Writer] to bytes and writes
 // it into the output.
 func (w *Writer) Flush() error {
-	if err := w.makeSymbolTable(); err != nil {
+	symtabIx := w.header.Shnum
+
+	symIndex, err := w.makeSymbolTable()
+	if err != nil {
 		return err
 	}
 
@@ -188,6 +240,10 @@ func (w *Writer) Flush() error {
 		return err
 	}
 
+	if err := w.writeRelocations(uint32(symtabIx), symIndex); err != nil {
+		return err
+	}
+
 	if err := w.writeShstrtab(); err != nil {
 		return err
 	}
@@ -202,38 +258,119 @@ func (w *Writer) Flush() error {
 		return err
 	}
 
-	_, err := w.code.WriteTo(w.output)
+	_, err = w.code.WriteTo(w.output)
 	return err
 }
 
-func (w *Writer) makeSymbolTable() error {
-	slices.SortFunc(w.symbols, func(a, b elf.Sym64) int {
+// makeSymbolTable writes the symbol table section. It returns a function,
+// which looks up the index of the symbol a relocation refers to. Sections are
+// looked up separately from the other symbols, which can share their names.
+func (w *Writer) makeSymbolTable() (func(Relocation64) (uint32, bool), error) {
+	// Local symbols must precede the global ones.
+	slices.SortStableFunc(w.symbols, func(a, b elf.Sym64) int {
 		return int(a.Info>>4) - int(b.Info>>4)
 	})
 
+	// The symbol table's info holds the index of the first non-local symbol,
+	// which is one past the last symbol if all of them are local.
+	firstGlobalIx := slices.IndexFunc(w.symbols, func(symb elf.Sym64) bool {
+		return elf.ST_BIND(symb.Info) != elf.STB_LOCAL
+	})
+	if firstGlobalIx == -1 {
+		firstGlobalIx = len(w.symbols)
+	}
+
 	buf := bytes.NewBuffer(make([]byte, 0, Symbol64Size*len(w.symbols)))
-	var firstGlobalIx uint32
+	byOff := map[uint32]uint32{}
+	bySect := map[uint16]uint32{}
+
 	for i, symb := range w.symbols {
-		if firstGlobalIx == 0 && (symb.Info>>4) == uint8(elf.STB_GLOBAL) {
-			firstGlobalIx = uint32(i)
+		if elf.SymType(symb.Info&0xf) == elf.STT_SECTION {
+			bySect[symb.Shndx] = uint32(i)
+		} else {
+			byOff[symb.Name] = uint32(i)
 		}
 
 		if err := writeSymbolToBuffer(buf, symb); err != nil {
-			return err
+			return nil, err
+		}
+	}
+
+	symIndex := func(rel Relocation64) (uint32, bool) {
+		if rel.Symbol == "" {
+			shndx, ok := w.shndx[rel.Section]
+			if !ok || rel.Section == "" {
+				return 0, false
+			}
+
+			ix, ok := bySect[shndx]
+			return ix, ok
 		}
+
+		off, ok := w.symOff[rel.Symbol]
+		if !ok {
+			return 0, false
+		}
+
+		ix, ok := byOff[off]
+		return ix, ok
 	}
 
-	return w.WriteSection(Section64{
+	return symIndex, w.WriteSection(Section64{
 		Name:      ".symtab",
 		Type:      elf.SHT_SYMTAB,
 		Link:      uint32(len(w.sections)) + 1,
-		Info:      firstGlobalIx,
+		Info:      uint32(firstGlobalIx),
 		Addralign: 8,
 		Entsize:   Symbol64Size,
 		Code:      buf.Bytes(),
 	})
 }
 
+func (w *Writer) writeRelocations(symtabIx uint32, symIndex func(Relocation64) (uint32, bool)) error {
+	for _, sect := range w.relocSects {
+		shndx, ok := w.shndx[sect]
+		if !ok {
+			return fmt.Errorf("relocated section %q doesn't exist", sect)
+		}
+
+		buf := bytes.NewBuffer(make([]byte, 0, Rela64Size*len(w.relocs[sect])))
+		for _, rel := range w.relocs[sect] {
+			symIx, ok := symIndex(rel)
+			if !ok && rel.Symbol == "" {
+				return fmt.Errorf("relocation refers to unknown section %q", rel.Section)
+			} else if !ok {
+				return fmt.Errorf("relocation refers to unknown symbol %q", rel.Symbol)
+			}
+
+			err := binary.Write(buf, binary.LittleEndian, elf.Rela64{
+				Off:    rel.Offset,
+				Info:   elf.R_INFO(symIx, rel.Type),
+				Addend: rel.Addend,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		err := w.WriteSection(Section64{
+			Name:      ".rela" + sect,
+			Type:      elf.SHT_RELA,
+			Flags:     elf.SHF_INFO_LINK,
+			Link:      symtabIx,
+			Info:      uint32(shndx),
+			Addralign: 8,
+			Entsize:   Rela64Size,
+			Code:      buf.Bytes(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (w *Writer) writeShstrtab() error {
 	w.header.Shstrndx = uint16(len(w.sections))
 	if err := w.writeStringTable(".shstrtab", &w.shstrtab); err != nil {
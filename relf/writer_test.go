@@ -3,6 +3,8 @@ package relf_test
 import (
 	"bytes"
 	"debug/elf"
+	"encoding/binary"
+	"reflect"
 	"testing"
 
 	"github.com/nilhiu/rei/relf"
@@ -75,6 +77,29 @@ func TestELFWriter(t *testing.T) {
 			wantSymbs: []relf.Symbol64{},
 			wantErr:   false,
 		},
+		{
+			name: "Should generate correctful ELF file with a nobits section",
+			wantSects: []relf.Section64{
+				{
+					Name:      ".data",
+					Code:      []byte{0xAA},
+					Type:      elf.SHT_PROGBITS,
+					Addralign: 4,
+					Entsize:   0,
+					Flags:     elf.SHF_WRITE | elf.SHF_ALLOC,
+				},
+				{
+					Name:      ".bss",
+					Size:      0x100000,
+					Type:      elf.SHT_NOBITS,
+					Addralign: 16,
+					Entsize:   0,
+					Flags:     elf.SHF_WRITE | elf.SHF_ALLOC,
+				},
+			},
+			wantSymbs: []relf.Symbol64{},
+			wantErr:   false,
+		},
 		{
 			name: "Should generate correctful ELF file with multiple sections and symbols",
 			wantSects: []relf.Section64{
@@ -121,6 +146,43 @@ func TestELFWriter(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Should generate correctful ELF file with local and global symbols",
+			wantSects: []relf.Section64{
+				{
+					Name:      ".text",
+					Code:      []byte{0x48, 0xC7, 0xC0, 0xFF, 0xFF, 0x00, 0x00},
+					Type:      elf.SHT_PROGBITS,
+					Addralign: 16,
+					Entsize:   0,
+					Flags:     elf.SHF_EXECINSTR | elf.SHF_ALLOC,
+				},
+			},
+			wantSymbs: []relf.Symbol64{
+				{
+					Name:  "_start",
+					Type:  elf.STT_NOTYPE,
+					Bind:  elf.STB_GLOBAL,
+					Shndx: 1,
+					Value: 0,
+				},
+				{
+					Name:  "loop",
+					Type:  elf.STT_NOTYPE,
+					Bind:  elf.STB_LOCAL,
+					Shndx: 1,
+					Value: 3,
+				},
+				{
+					Name:  "puts",
+					Type:  elf.STT_NOTYPE,
+					Bind:  elf.STB_GLOBAL,
+					Shndx: uint16(elf.SHN_UNDEF),
+					Value: 0,
+				},
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -144,6 +206,10 @@ func TestELFWriter(t *testing.T) {
 			}
 			defer buf.Reset()
 
+			if buf.Len() > 0x10000 {
+				t.Errorf("buf.Len() = %d, nobits sections shouldn't take up space", buf.Len())
+			}
+
 			if tt.wantErr {
 				t.Fatal("Next() succeeded unexpectedly")
 			}
@@ -158,6 +224,15 @@ func TestELFWriter(t *testing.T) {
 				if !equalSect(wantSect, *gotSect) {
 					t.Errorf("gotSymb = %v, want %v", *gotSect, wantSect)
 				}
+
+				wantSize := uint64(len(wantSect.Code))
+				if wantSect.Type == elf.SHT_NOBITS {
+					wantSize = wantSect.Size
+				}
+
+				if gotSect.Size != wantSize {
+					t.Errorf("gotSect.Size = %d, want %d", gotSect.Size, wantSize)
+				}
 			}
 
 			gotSymbs, err := gotFile.Symbols()
@@ -165,6 +240,19 @@ func TestELFWriter(t *testing.T) {
 				t.Errorf("gotFile.Symbols() failed to return symbols: %v", err)
 			}
 
+			// Symbols() skips the null symbol, which is local.
+			firstGlobalIx := len(gotSymbs) + 1
+			for i, symb := range gotSymbs {
+				if elf.ST_BIND(symb.Info) != elf.STB_LOCAL {
+					firstGlobalIx = i + 1
+					break
+				}
+			}
+
+			if symtab := gotFile.SectionByType(elf.SHT_SYMTAB); int(symtab.Info) != firstGlobalIx {
+				t.Errorf("symtab.Info = %d, want %d", symtab.Info, firstGlobalIx)
+			}
+
 			for _, wantSymb := range tt.wantSymbs {
 				gotSymb := findSymb(wantSymb.Name, gotSymbs)
 				if gotSymb == nil {
@@ -237,3 +325,124 @@ func findSymb(name string, symbs []elf.Symbol) *elf.Symbol {
 
 	return nil
 }
+
+func TestELFWriterRelocations(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	w := relf.New("test.S", relf.Header64{
+		Endian:  elf.ELFDATA2LSB,
+		ABI:     elf.ELFOSABI_NONE,
+		Machine: elf.EM_X86_64,
+	}, buf)
+
+	sects := []relf.Section64{
+		{
+			Name:      ".text",
+			Code:      []byte{0xE8, 0x00, 0x00, 0x00, 0x00, 0x48, 0x8D, 0x35, 0x00, 0x00, 0x00, 0x00},
+			Type:      elf.SHT_PROGBITS,
+			Addralign: 16,
+			Flags:     elf.SHF_EXECINSTR | elf.SHF_ALLOC,
+		},
+		{
+			Name:      ".data",
+			Code:      []byte{0xAA},
+			Type:      elf.SHT_PROGBITS,
+			Addralign: 4,
+			Flags:     elf.SHF_WRITE | elf.SHF_ALLOC,
+		},
+	}
+	for _, sect := range sects {
+		if err := w.WriteSection(sect); err != nil {
+			t.Fatalf("w.WriteSection(sect) failed to write section: %v", err)
+		}
+	}
+
+	// The label shares its name with a section, whose relocations must still
+	// refer to the section.
+	symbs := []relf.Symbol64{
+		{Name: "puts", Type: elf.STT_NOTYPE, Bind: elf.STB_GLOBAL, Shndx: uint16(elf.SHN_UNDEF)},
+		{Name: ".data", Type: elf.STT_NOTYPE, Bind: elf.STB_LOCAL, Shndx: 1, Value: 5},
+	}
+	for _, symb := range symbs {
+		if err := w.WriteSymbol(symb); err != nil {
+			t.Fatalf("w.WriteSymbol(symb) failed to write symbol: %v", err)
+		}
+	}
+
+	wantRelocs := []relf.Relocation64{
+		{Offset: 1, Symbol: "puts", Type: uint32(elf.R_X86_64_PLT32), Addend: -4},
+		{Offset: 8, Section: ".data", Type: uint32(elf.R_X86_64_PC32), Addend: -4},
+	}
+	for _, rel := range wantRelocs {
+		if err := w.WriteRelocation(".text", rel); err != nil {
+			t.Fatalf("w.WriteRelocation(rel) failed to write relocation: %v", err)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("w.Flush() failed: %v", err)
+	}
+
+	gotFile, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("elf.NewFile(...) failed to read generated file: %v", err)
+	}
+
+	rela := gotFile.Section(".rela.text")
+	if rela == nil {
+		t.Fatal("gotFile.Section(.rela.text) returned nil, doesn't exist")
+	}
+
+	if rela.Type != elf.SHT_RELA || rela.Entsize != relf.Rela64Size {
+		t.Errorf("rela = %v, want SHT_RELA section", rela.SectionHeader)
+	}
+
+	for _, sect := range gotFile.Sections {
+		if sect.Addralign > 1 && sect.Offset%sect.Addralign != 0 {
+			t.Errorf("%s.Offset = %#x, want it aligned to %d", sect.Name, sect.Offset, sect.Addralign)
+		}
+	}
+
+	if gotFile.Sections[rela.Link].Type != elf.SHT_SYMTAB {
+		t.Errorf("rela.Link = %d, doesn't refer to the symbol table", rela.Link)
+	}
+
+	if gotFile.Sections[rela.Info].Name != ".text" {
+		t.Errorf("rela.Info = %d, doesn't refer to .text", rela.Info)
+	}
+
+	data, err := rela.Data()
+	if err != nil {
+		t.Fatalf("rela.Data() failed: %v", err)
+	}
+
+	gotSymbs, err := gotFile.Symbols()
+	if err != nil {
+		t.Fatalf("gotFile.Symbols() failed to return symbols: %v", err)
+	}
+
+	var gotRelocs []relf.Relocation64
+	for i := 0; i+relf.Rela64Size <= len(data); i += relf.Rela64Size {
+		var rel elf.Rela64
+		if err := binary.Read(bytes.NewReader(data[i:]), binary.LittleEndian, &rel); err != nil {
+			t.Fatalf("binary.Read(...) failed to read relocation: %v", err)
+		}
+
+		// Symbols() skips the null symbol, so the indices are off by one.
+		symb := gotSymbs[elf.R_SYM64(rel.Info)-1]
+		gotRel := relf.Relocation64{
+			Offset: rel.Off,
+			Symbol: symb.Name,
+			Type:   elf.R_TYPE64(rel.Info),
+			Addend: rel.Addend,
+		}
+		if elf.ST_TYPE(symb.Info) == elf.STT_SECTION {
+			gotRel.Symbol, gotRel.Section = "", gotFile.Sections[symb.Section].Name
+		}
+
+		gotRelocs = append(gotRelocs, gotRel)
+	}
+
+	if !reflect.DeepEqual(gotRelocs, wantRelocs) {
+		t.Errorf("gotRelocs = %v, want %v", gotRelocs, wantRelocs)
+	}
+}
@@ -1,13 +1,19 @@
 package x86
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"errors"
+)
 
 // An Address represents a SIB + displacement encoding of a memory address.
 type Address struct {
 	Scale        byte
 	Index        Register
 	Base         Register
-	Displacement uint32
+	Displacement int32
+	// Size is the size, in bits, of the memory operand at the address. It's
+	// zero if it's determined by the other operands.
+	Size uint
 }
 
 // EncodeSIB encodes the [Address] as an SIB byte.
@@ -17,10 +23,7 @@ func (a Address) EncodeSIB() byte {
 	}
 
 	var scale byte
-
 	switch a.Scale {
-	case 1:
-		scale = 0b00
 	case 2:
 		scale = 0b01
 	case 4:
@@ -29,35 +32,119 @@ func (a Address) EncodeSIB() byte {
 		scale = 0b11
 	}
 
-	return (scale << 6) | (a.Index.EncodeByte() << 3) | a.Base.EncodeByte()
+	index := byte(0b100)
+	if a.Index != NilReg {
+		index = a.Index.EncodeByte()
+	}
+
+	base := byte(0b101)
+	if a.Base != NilReg {
+		base = a.Base.EncodeByte()
+	}
+
+	return (scale << 6) | (index << 3) | base
+}
+
+// encode encodes the address as a ModR/M byte, with the given value in its reg
+// field, followed by the SIB byte and the displacement, if they're needed.
+func (a Address) encode(reg byte) ([]byte, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+
+	mod := a.mod()
+	if !a.isSIB() {
+		return append([]byte{encodeModRM(mod, reg, a.Base.EncodeByte())}, a.disp(mod)...), nil
+	}
+
+	// Set ModR/M byte's R/M field to 4 (0b100) as SIB is to be encoded.
+	return append([]byte{encodeModRM(mod, reg, 0b100), a.EncodeSIB()}, a.disp(mod)...), nil
+}
+
+func (a Address) validate() error {
+	if a.Base == RIP && a.Index != NilReg {
+		return errors.New("RIP-relative address cannot have an index register")
+	} else if a.Index == RIP {
+		return errors.New("RIP cannot be used as an index register")
+	}
+
+	if a.Index == RSP || a.Index == ESP {
+		return errors.New("stack pointer cannot be used as an index register")
+	}
+
+	switch a.Scale {
+	case 0, 1, 2, 4, 8:
+	default:
+		return errors.New("scale of an address must be 1, 2, 4 or 8")
+	}
+
+	for _, reg := range []Register{a.Index, a.Base} {
+		if reg != NilReg && reg.Size() != 32 && reg.Size() != 64 {
+			return errors.New("address registers must be 32-bit or 64-bit")
+		}
+	}
+
+	if a.Index != NilReg && a.Base != NilReg && a.Index.Size() != a.Base.Size() {
+		return errors.New("address registers must be the same size")
+	}
+
+	return nil
 }
 
 func (a Address) mod() byte {
-	if a.Displacement == 0 {
+	if a.Base == NilReg || a.Base == RIP {
+		// Without a base register, or with RIP as one, a 32-bit displacement is
+		// always encoded.
 		return 0b00
-	} else if a.Displacement <= 0x7F {
+	}
+
+	// RBP and R13 as a base can only be encoded with a displacement.
+	if a.Displacement == 0 && a.Base.EncodeByte() != 0b101 {
+		return 0b00
+	} else if a.Displacement >= -0x80 && a.Displacement <= 0x7F {
 		return 0b01
 	}
 
 	return 0b10
 }
 
-func (a Address) disp() []byte {
-	if a.Displacement <= 0x7F {
+func (a Address) disp(mod byte) []byte {
+	switch {
+	case mod == 0b01:
 		return []byte{byte(a.Displacement)}
+	case mod == 0b10, a.Base == NilReg, a.Base == RIP:
+		return binary.LittleEndian.AppendUint32([]byte{}, uint32(a.Displacement))
+	}
+
+	return nil
+}
+
+func (a Address) dispSize() int {
+	return len(a.disp(a.mod()))
+}
+
+// addrSize returns the size, in bits, of the address. Addresses without
+// registers are 64-bit.
+func (a Address) addrSize() uint {
+	if a.Base != NilReg {
+		return a.Base.Size()
+	} else if a.Index != NilReg {
+		return a.Index.Size()
 	}
 
-	b := make([]byte, 4)
-	binary.LittleEndian.PutUint32(b, a.Displacement)
+	return 64
+}
 
-	return b
+func (a Address) isREX() bool {
+	return a.Index.IsREXB() || a.Base.IsREXB()
 }
 
 func (a Address) isNil() bool {
-	return a.Scale == 0 && a.Index == NilReg && a.Base == NilReg &&
-		a.Displacement == 0
+	return a.Index == NilReg && a.Base == NilReg
 }
 
 func (a Address) isSIB() bool {
-	return (a.Scale != 1) || (a.Index != NilReg)
+	// RSP and R12 as a base can only be encoded through the SIB byte.
+	return a.Index != NilReg || a.Base == NilReg ||
+		(a.Base != RIP && a.Base.EncodeByte() == 0b100)
 }
@@ -7,11 +7,99 @@ const (
 	_            = iota
 	ADD Mnemonic = iota << 5
 	MOV
+	LEA
+	JMP
+
+	// The Jcc mnemonics are ordered by their condition codes.
+	JO
+	JNO
+	JB
+	JAE
+	JE
+	JNE
+	JBE
+	JA
+	JS
+	JNS
+	JP
+	JNP
+	JL
+	JGE
+	JLE
+	JG
+
+	CALL
+)
+
+// Aliases of the Jcc mnemonics.
+const (
+	JC   = JB
+	JNAE = JB
+	JNB  = JAE
+	JNC  = JAE
+	JZ   = JE
+	JNZ  = JNE
+	JNA  = JBE
+	JNBE = JA
+	JPE  = JP
+	JPO  = JNP
+	JNGE = JL
+	JNL  = JGE
+	JNG  = JLE
+	JNLE = JG
 )
 
 // MnemonicSearchMap maps the string representation of mnemonics to their
 // [Mnemonic] counterparts.
 var MnemonicSearchMap = map[string]Mnemonic{
-	"add": ADD,
-	"mov": MOV,
+	"add":  ADD,
+	"call": CALL,
+	"jmp":  JMP,
+	"lea":  LEA,
+	"mov":  MOV,
+
+	"jo":   JO,
+	"jno":  JNO,
+	"jb":   JB,
+	"jc":   JC,
+	"jnae": JNAE,
+	"jae":  JAE,
+	"jnb":  JNB,
+	"jnc":  JNC,
+	"je":   JE,
+	"jz":   JZ,
+	"jne":  JNE,
+	"jnz":  JNZ,
+	"jbe":  JBE,
+	"jna":  JNA,
+	"ja":   JA,
+	"jnbe": JNBE,
+	"js":   JS,
+	"jns":  JNS,
+	"jp":   JP,
+	"jpe":  JPE,
+	"jnp":  JNP,
+	"jpo":  JPO,
+	"jl":   JL,
+	"jnge": JNGE,
+	"jge":  JGE,
+	"jnl":  JNL,
+	"jle":  JLE,
+	"jng":  JNG,
+	"jg":   JG,
+	"jnle": JNLE,
+}
+
+// IsBranch reports if the mnemonic's operand is a [Relative] branch target.
+func (mnem Mnemonic) IsBranch() bool {
+	return mnem == CALL || mnem == JMP || mnem.isJcc()
+}
+
+func (mnem Mnemonic) isJcc() bool {
+	return mnem >= JO && mnem <= JG
+}
+
+// conditionCode returns the condition code of a Jcc mnemonic.
+func (mnem Mnemonic) conditionCode() byte {
+	return byte((mnem - JO) >> 5)
 }
@@ -7,6 +7,7 @@ const (
 	OpImmediate OpType = iota // the operand in an immediate/constant
 	OpRegister                // the operand is a register
 	OpAddress                 // the operand is an address
+	OpRelative                // the operand is a relative branch target
 )
 
 // A Operand is a interface, which operands have to implement.
@@ -23,8 +24,10 @@ func (r Register) Value() uint {
 	return uint(r.EncodeByte())
 }
 
-// A Immediate represents an immediate, or a constant, value.
-type Immediate uint
+// A Immediate represents an immediate, or a constant, value. It's signed, but
+// values up to the maximum of an unsigned field can be given as well, as long
+// as the field isn't sign-extended.
+type Immediate int64
 
 func (imm Immediate) Type() OpType {
 	return OpImmediate
@@ -41,3 +44,19 @@ func (a Address) Type() OpType {
 func (a Address) Value() uint {
 	return uint(a.EncodeSIB())
 }
+
+// A Relative represents the target of a branch, relative to the start of the
+// branch instruction. The shortest encoding which can reach the target is used,
+// unless Near is set.
+type Relative struct {
+	Offset int64
+	Near   bool // forces the use of a 32-bit displacement
+}
+
+func (r Relative) Type() OpType {
+	return OpRelative
+}
+
+func (r Relative) Value() uint {
+	return uint(r.Offset)
+}
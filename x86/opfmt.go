@@ -1,6 +1,6 @@
 package x86
 
-type translateFunc func([]Operand) ([]byte, error)
+type translateFunc func([]Operand) (Instruction, error)
 
 type opFmt struct {
 	operands   [][]OpType
@@ -18,6 +18,9 @@ type immFmt struct {
 const (
 	opFmtClassNotChange  = byte(1 << 7)
 	opFmtClassCompactReg = byte(1 << 6)
+	// opFmtClassAnyMemSize marks mnemonics, which don't access the memory
+	// at their address operand, so its size doesn't matter.
+	opFmtClassAnyMemSize = byte(1 << 5)
 )
 
 var (
@@ -52,7 +55,30 @@ func (o *opFmt) addRR(base []byte, mustSameSize bool) *opFmt {
 
 func (o *opFmt) addRA(base []byte) *opFmt {
 	o.operands = append(o.operands, []OpType{OpRegister, OpAddress})
-	o.translates = append(o.translates, gRA(base))
+	o.translates = append(o.translates, gRA(base, o.class))
+
+	return o
+}
+
+func (o *opFmt) addAR(base []byte) *opFmt {
+	o.operands = append(o.operands, []OpType{OpAddress, OpRegister})
+	o.translates = append(o.translates, gAR(base, o.class))
+
+	return o
+}
+
+func (o *opFmt) addAI(base []byte, immFmt immFmt) *opFmt {
+	o.operands = append(o.operands, []OpType{OpAddress, OpImmediate})
+	o.translates = append(o.translates, gAI(base, o.class, immFmt))
+
+	return o
+}
+
+// addRel adds a branch to a relative target. The short encoding may be nil, if
+// the branch only has a near encoding.
+func (o *opFmt) addRel(short []byte, near []byte) *opFmt {
+	o.operands = append(o.operands, []OpType{OpRelative})
+	o.translates = append(o.translates, gRel(short, near))
 
 	return o
 }
@@ -67,16 +93,51 @@ func (o *opFmt) withARegCompressed(base []byte, immFmt immFmt) *opFmt {
 	return o
 }
 
+// withByteCompressed uses the given opcode, with a sign-extended 8-bit
+// immediate, for the last added register, or address, and immediate encoding,
+// when the immediate fits in a byte.
 func (o *opFmt) withByteCompressed(base []byte) *opFmt {
+	compressed := gRI(base, o.class|opFmtClassNotChange, immFmtByte)
+	if o.operands[len(o.operands)-1][0] == OpAddress {
+		compressed = gAI(base, o.class|opFmtClassNotChange, immFmtByte)
+	}
+
 	o.translates[len(o.translates)-1] = pIf(
-		func(ops []Operand) bool { return ops[0].(Register).Size() != 8 && ops[1].Value() <= 0x7F },
-		gRI(base, o.class|opFmtClassNotChange, immFmtByte),
+		func(ops []Operand) bool {
+			size := opSize(ops[0])
+			return size != 8 && size != 0 && ops[1].(Immediate).fits(8, size)
+		},
+		compressed,
 		o.translates[len(o.translates)-1],
 	)
 
 	return o
 }
 
+// opSize returns the size, in bits, of a register, or memory, operand.
+func opSize(op Operand) uint {
+	if addr, ok := op.(Address); ok {
+		return addr.Size
+	}
+
+	return op.(Register).Size()
+}
+
+func (o *opFmt) withoutByteReg() *opFmt {
+	o.translates[len(o.translates)-1] = pErr(
+		func(ops []Operand) bool { return ops[0].(Register).Size() == 8 },
+		"given mnemonic cannot be used with 8-bit registers",
+		o.translates[len(o.translates)-1],
+	)
+
+	return o
+}
+
+// sizeOf returns the size, in bytes, of the immediate used with the given register.
+func (i immFmt) sizeOf(reg Register) int {
+	return int(i.getBySize(reg.Size())) / 8
+}
+
 func (i immFmt) getBySize(sz uint) byte {
 	switch sz {
 	case 8:
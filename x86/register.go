@@ -23,6 +23,8 @@ func (reg Register) EncodeByte() byte {
 		return 6
 	case BH, DI, EDI, DIL, RDI, R15B, R15W, R15D, R15:
 		return 7
+	case RIP:
+		return 0b101
 	case NilReg:
 		return 0
 	default:
@@ -40,7 +42,7 @@ func (reg Register) Size() uint {
 		return 16
 	case EAX, ECX, EDX, EBX, ESI, EDI, ESP, EBP, R8D, R9D, R10D, R11D, R12D, R13D, R14D, R15D:
 		return 32
-	case RAX, RCX, RDX, RBX, RSI, RDI, RSP, RBP, R8, R9, R10, R11, R12, R13, R14, R15:
+	case RAX, RCX, RDX, RBX, RSI, RDI, RSP, RBP, R8, R9, R10, R11, R12, R13, R14, R15, RIP:
 		return 64
 	case NilReg:
 		return 0
@@ -166,6 +168,7 @@ const (
 	CH
 	DH
 	BH
+	RIP // can only be used as the base of an address
 )
 
 // RegisterSearchMap maps the string representation of registers to their
@@ -243,4 +246,6 @@ var RegisterSearchMap = map[string]Register{
 	"ch": CH,
 	"dh": DH,
 	"bh": BH,
+
+	"rip": RIP,
 }
@@ -7,17 +7,59 @@ import (
 	"slices"
 )
 
+var (
+	// ErrUnknownMnemonic is returned when the mnemonic has no known encoding.
+	ErrUnknownMnemonic = errors.New("unknown mnemonic encountered")
+	// ErrOperandMismatch is returned when the mnemonic can't be encoded with
+	// the given operands.
+	ErrOperandMismatch = errors.New("given operands for this mnemonic are unsupported")
+	// ErrOutOfRange is wrapped by the errors of values, which don't fit in
+	// their encoding.
+	ErrOutOfRange = errors.New("out of range")
+)
+
+// An Instruction represents an encoded instruction. As the displacement and
+// the immediate of an instruction are always at its end, only their sizes
+// are saved.
+type Instruction struct {
+	Bytes    []byte // the machine code of the instruction
+	DispSize int    // the size of the displacement, in bytes
+	ImmSize  int    // the size of the immediate, in bytes
+}
+
+// DispOffset returns the offset of the displacement in the instruction.
+func (i Instruction) DispOffset() int {
+	return len(i.Bytes) - i.ImmSize - i.DispSize
+}
+
+// ImmOffset returns the offset of the immediate in the instruction.
+func (i Instruction) ImmOffset() int {
+	return len(i.Bytes) - i.ImmSize
+}
+
 // Translate translates the provided mnemonic and operands into x86 machine
 // code. An error can occur if the given mnemonic is unknown, or if the given
 // operands don't match what the mnemonic should be given.
 func Translate(mnem Mnemonic, ops ...Operand) ([]byte, error) {
+	inst, err := Encode(mnem, ops...)
+
+	return inst.Bytes, err
+}
+
+// Encode works like [Translate], but returns the encoded [Instruction], which
+// locates its displacement and immediate.
+func Encode(mnem Mnemonic, ops ...Operand) (Instruction, error) {
 	fmt := mnemToFmt(mnem)
 	if fmt == nil {
-		return nil, errors.New("unknown mnemonic encountered")
+		return Instruction{}, ErrUnknownMnemonic
 	}
 
 	opTypes := []OpType{}
 	for _, op := range ops {
+		if op == RIP {
+			return Instruction{}, errors.New("RIP can only be used as the base of an address")
+		}
+
 		opTypes = append(opTypes, op.Type())
 	}
 
@@ -32,13 +74,19 @@ func Translate(mnem Mnemonic, ops ...Operand) ([]byte, error) {
 	}
 
 	if ix == ^uint(0) {
-		return nil, errors.New("given operands for this mnemonic are unsupported")
+		return Instruction{}, ErrOperandMismatch
 	}
 
 	return fmt.translates[ix](ops)
 }
 
 func mnemToFmt(mnem Mnemonic) *opFmt {
+	if mnem.isJcc() {
+		cc := mnem.conditionCode()
+
+		return newOpFmt().addRel([]byte{0x70 | cc}, []byte{0x0F, 0x80 | cc})
+	}
+
 	switch mnem {
 	case ADD:
 		return newOpFmt().
@@ -46,13 +94,28 @@ func mnemToFmt(mnem Mnemonic) *opFmt {
 			addRI([]byte{0x80}, immFmtNative32).
 			withARegCompressed([]byte{0x04}, immFmtNative32).
 			withByteCompressed([]byte{0x83}).
-			addRR([]byte{0x00}, true)
+			addRR([]byte{0x00}, true).
+			addRA([]byte{0x02}).
+			addAR([]byte{0x00}).
+			addAI([]byte{0x80}, immFmtNative32).
+			withByteCompressed([]byte{0x83})
 	case MOV:
 		return newOpFmt().
 			withClass(opFmtClassCompactReg).
 			addRI([]byte{0xB0}, immFmtNative).
 			addRR([]byte{0x88}, true).
-			addRA([]byte{0x8A})
+			addRA([]byte{0x8A}).
+			addAR([]byte{0x88}).
+			addAI([]byte{0xC6}, immFmtNative32)
+	case CALL:
+		return newOpFmt().addRel(nil, []byte{0xE8})
+	case JMP:
+		return newOpFmt().addRel([]byte{0xEB}, []byte{0xE9})
+	case LEA:
+		return newOpFmt().
+			withClass(opFmtClassNotChange | opFmtClassAnyMemSize).
+			addRA([]byte{0x8D}).
+			withoutByteReg()
 	}
 
 	return nil
@@ -3,10 +3,12 @@ package x86
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"slices"
 )
 
 func pIf(pred func(ops []Operand) bool, then translateFunc, otherwise translateFunc) translateFunc {
-	return func(ops []Operand) ([]byte, error) {
+	return func(ops []Operand) (Instruction, error) {
 		if pred(ops) {
 			return then(ops)
 		}
@@ -15,27 +17,79 @@ func pIf(pred func(ops []Operand) bool, then translateFunc, otherwise translateF
 	}
 }
 
-func gRR(base []byte, mustSameSize bool) func([]Operand) ([]byte, error) {
-	return func(ops []Operand) ([]byte, error) {
-		return genericRegReg(base, mustSameSize, ops[0].(Register), ops[1].(Register))
+func pErr(pred func(ops []Operand) bool, msg string, otherwise translateFunc) translateFunc {
+	return func(ops []Operand) (Instruction, error) {
+		if pred(ops) {
+			return Instruction{}, errors.New(msg)
+		}
+
+		return otherwise(ops)
+	}
+}
+
+func gRR(base []byte, mustSameSize bool) translateFunc {
+	return func(ops []Operand) (Instruction, error) {
+		bytes, err := genericRegReg(base, mustSameSize, ops[0].(Register), ops[1].(Register))
+
+		return Instruction{Bytes: bytes}, err
+	}
+}
+
+func gRI(base []byte, class byte, immFmt immFmt) translateFunc {
+	return func(ops []Operand) (Instruction, error) {
+		reg := ops[0].(Register)
+		bytes, err := genericRegImm(base, class, immFmt, reg, ops[1].(Immediate))
+
+		return Instruction{Bytes: bytes, ImmSize: immFmt.sizeOf(reg)}, err
+	}
+}
+
+func gRA(base []byte, class byte) translateFunc {
+	return func(ops []Operand) (Instruction, error) {
+		addr := ops[1].(Address)
+		bytes, err := genericRegAddr(base, class, ops[0].(Register), addr)
+
+		return Instruction{Bytes: bytes, DispSize: addr.dispSize()}, err
 	}
 }
 
-func gRI(base []byte, class byte, immFmt immFmt) func([]Operand) ([]byte, error) {
-	return func(ops []Operand) ([]byte, error) {
-		return genericRegImm(base, class, immFmt, ops[0].(Register), ops[1].(Immediate))
+func gAR(base []byte, class byte) translateFunc {
+	return func(ops []Operand) (Instruction, error) {
+		addr := ops[0].(Address)
+		bytes, err := genericRegAddr(base, class, ops[1].(Register), addr)
+
+		return Instruction{Bytes: bytes, DispSize: addr.dispSize()}, err
+	}
+}
+
+func cRI(base []byte, immFmt immFmt) translateFunc {
+	return func(ops []Operand) (Instruction, error) {
+		reg := ops[0].(Register)
+		bytes, err := compressedRegImm(base, immFmt, reg, ops[1].(Immediate))
+
+		return Instruction{Bytes: bytes, ImmSize: immFmt.sizeOf(reg)}, err
 	}
 }
 
-func gRA(base []byte) func([]Operand) ([]byte, error) {
-	return func(ops []Operand) ([]byte, error) {
-		return genericRegAddr(base, ops[0].(Register), ops[1].(Address))
+func gAI(base []byte, class byte, immFmt immFmt) translateFunc {
+	return func(ops []Operand) (Instruction, error) {
+		addr := ops[0].(Address)
+		bytes, err := genericAddrImm(base, class, immFmt, addr, ops[1].(Immediate))
+		if err != nil {
+			return Instruction{}, err
+		}
+
+		return Instruction{
+			Bytes:    bytes,
+			DispSize: addr.dispSize(),
+			ImmSize:  int(immFmt.getBySize(addr.Size)) / 8,
+		}, nil
 	}
 }
 
-func cRI(base []byte, immFmt immFmt) func([]Operand) ([]byte, error) {
-	return func(ops []Operand) ([]byte, error) {
-		return compressedRegImm(base, immFmt, ops[0].(Register), ops[1].(Immediate))
+func gRel(short []byte, near []byte) translateFunc {
+	return func(ops []Operand) (Instruction, error) {
+		return relative(short, near, ops[0].(Relative))
 	}
 }
 
@@ -46,7 +100,7 @@ func genericRegImm(
 	reg Register,
 	imm Immediate,
 ) ([]byte, error) {
-	immBytes, err := translateImmByFmt(imm.Value(), reg, immFmt)
+	immBytes, err := translateImmByFmt(imm, reg.Size(), immFmt)
 	if err != nil {
 		return nil, err
 	}
@@ -75,28 +129,84 @@ func genericRegReg(
 
 func genericRegAddr(
 	base []byte,
+	class byte,
 	reg Register,
 	addr Address,
 ) ([]byte, error) {
-	// TODO: check @addr.size == reg.size
-	if reg.IsREX() && reg.IsREXExcluded() {
+	if addr.Size != 0 && addr.Size != reg.Size() && class&opFmtClassAnyMemSize == 0 {
+		return nil, fmt.Errorf(
+			"%w, as the %d-bit memory operand doesn't match the %d-bit register",
+			ErrOperandMismatch, addr.Size, reg.Size(),
+		)
+	}
+
+	if (reg.IsREX() || addr.isREX()) && reg.IsREXExcluded() {
 		return nil, errors.New("given register cannot be encoded with a REX prefix")
 	}
 
-	opcode := genericRegNoPrefix(base, addr.Base, reg.EncodeByte(), addr.mod())
-	if addr.isSIB() {
-		// Set ModR/M byte's R/M field to 4 (0b100) as SIB is to be encoded.
-		opcode[len(opcode)-1] = (opcode[len(opcode)-1] & 0b11111000) | 0b100
-		opcode = append(opcode, addr.EncodeSIB())
+	addrBytes, err := addr.encode(reg.EncodeByte())
+	if err != nil {
+		return nil, err
+	}
+
+	opcode := slices.Clone(base)
+	if reg.Size() != 8 && class&opFmtClassNotChange == 0 {
+		opcode[len(opcode)-1]++
+	}
+
+	return append(append(prefixRA(reg, addr), opcode...), addrBytes...), nil
+}
+
+func genericAddrImm(
+	base []byte,
+	class byte,
+	immFmt immFmt,
+	addr Address,
+	imm Immediate,
+) ([]byte, error) {
+	switch addr.Size {
+	case 0:
+		return nil, errors.New("size of the memory operand must be given, such as 'dword [...]'")
+	case 8, 16, 32, 64:
+	default:
+		return nil, fmt.Errorf("%w, as the memory operand is %d-bit", ErrOperandMismatch, addr.Size)
+	}
+
+	immBytes, err := translateImmByFmt(imm, addr.Size, immFmt)
+	if err != nil {
+		return nil, err
+	}
+
+	addrBytes, err := addr.encode(class & 0b111)
+	if err != nil {
+		return nil, err
+	}
+
+	opcode := slices.Clone(base)
+	if addr.Size != 8 && class&opFmtClassNotChange == 0 {
+		opcode[len(opcode)-1]++
+	}
+
+	bytes := append(append(prefixA(addr), opcode...), addrBytes...)
+
+	return append(bytes, immBytes...), nil
+}
+
+func relative(short []byte, near []byte, rel Relative) (Instruction, error) {
+	// The displacement is relative to the end of the instruction.
+	disp := rel.Offset - int64(len(short)+1)
+	if short != nil && !rel.Near && disp >= -0x80 && disp <= 0x7F {
+		return Instruction{Bytes: append(slices.Clone(short), byte(disp)), ImmSize: 1}, nil
 	}
 
-	if addr.Displacement != 0 {
-		opcode = append(opcode, addr.disp()...)
-	} else if addr.isNil() { // HACK: Will be used for reallocation table. May change.
-		opcode = append(opcode, 0, 0, 0, 0)
+	disp = rel.Offset - int64(len(near)+4)
+	if disp < -0x80000000 || disp > 0x7FFFFFFF {
+		return Instruction{}, fmt.Errorf("branch target is %w", ErrOutOfRange)
 	}
 
-	return append(prefixR(reg), opcode...), nil
+	bytes := binary.LittleEndian.AppendUint32(slices.Clone(near), uint32(disp))
+
+	return Instruction{Bytes: bytes, ImmSize: 4}, nil
 }
 
 func genericReg(base []byte, reg Register, class byte) []byte {
@@ -130,7 +240,7 @@ func compressedRegImm(
 	reg Register,
 	imm Immediate,
 ) ([]byte, error) {
-	immBytes, err := translateImmByFmt(imm.Value(), reg, immFmt)
+	immBytes, err := translateImmByFmt(imm, reg.Size(), immFmt)
 	if err != nil {
 		return nil, err
 	}
@@ -171,8 +281,34 @@ func prefixR(reg Register) []byte {
 	return prefix
 }
 
-func translateImmByFmt(imm uint, reg Register, immFmt immFmt) ([]byte, error) {
-	sz := immFmt.getBySize(reg.Size())
+func prefixRA(reg Register, addr Address) []byte {
+	prefix := []byte{}
+	if reg.Size() == 16 {
+		prefix = []byte{0x66}
+	}
+
+	if addr.addrSize() == 32 {
+		prefix = append(prefix, 0x67)
+	}
+
+	if reg.IsREX() || addr.isREX() {
+		prefix = append(prefix, encodeRexRA(reg, addr))
+	}
+
+	return prefix
+}
+
+// translateImmByFmt encodes the immediate used with an operand of the given
+// size, in bits.
+func translateImmByFmt(imm Immediate, opSize uint, immFmt immFmt) ([]byte, error) {
+	sz := immFmt.getBySize(opSize)
+	if !imm.fits(uint(sz), opSize) {
+		lo, hi := immRange(uint(sz), opSize)
+		return nil, fmt.Errorf(
+			"immediate %d is %w, expected a value from %d to %d",
+			imm, ErrOutOfRange, lo, hi,
+		)
+	}
 
 	switch sz {
 	case 8:
@@ -188,6 +324,74 @@ func translateImmByFmt(imm uint, reg Register, immFmt immFmt) ([]byte, error) {
 	return nil, errors.New("unreachable")
 }
 
+// immRange returns the range of values, which an immediate field of the given
+// size, in bits, can hold for a register of regSize bits. Fields narrower than
+// the register are sign-extended, so they can only hold signed values.
+func immRange(size uint, regSize uint) (int64, int64) {
+	lo := -int64(1) << (size - 1)
+	if size < regSize {
+		return lo, -lo - 1
+	}
+
+	return lo, int64(1)<<size - 1
+}
+
+// fits reports if the immediate can be encoded in a field of the given size,
+// in bits, for a register of regSize bits. As the register wraps around, a
+// sign-extended field can hold any value which is the same in the register,
+// such as 0xFFFFFFFF for -1 in a 32-bit register.
+func (imm Immediate) fits(size uint, regSize uint) bool {
+	if size == 64 {
+		return true
+	}
+
+	val := int64(imm)
+	if size < regSize && regSize < 64 {
+		if lo, hi := immRange(regSize, regSize); val < lo || val > hi {
+			return false
+		}
+
+		shift := 64 - regSize
+		val = val << shift >> shift
+	}
+
+	lo, hi := immRange(size, regSize)
+
+	return val >= lo && val <= hi
+}
+
+// prefixA returns the prefixes of an instruction, whose only register operands
+// are the ones of the address.
+func prefixA(addr Address) []byte {
+	prefix := []byte{}
+	if addr.Size == 16 {
+		prefix = []byte{0x66}
+	}
+
+	if addr.addrSize() == 32 {
+		prefix = append(prefix, 0x67)
+	}
+
+	if addr.Size == 64 || addr.isREX() {
+		rex := byte(0x40)
+		if addr.Size == 64 {
+			rex |= 0x08
+		}
+
+		if addr.Index.IsREXB() {
+			rex |= 0x02
+		}
+
+		if addr.Base.IsREXB() {
+			rex |= 0x01
+		}
+
+		prefix = append(prefix, rex)
+	}
+
+	return prefix
+}
+
 func encodeModRM(mod byte, reg byte, mem byte) byte {
 	return (mod << 6) | (reg << 3) | mem
 }
@@ -196,6 +400,21 @@ func encodeRexR(reg Register) byte {
 	return encodeRexRR(reg, NilReg)
 }
 
+func encodeRexRA(reg Register, addr Address) byte {
+	rex := encodeRexRR(addr.Base, reg)
+	if reg.Size() == 64 {
+		rex |= 0x08
+	} else {
+		rex &^= 0x08
+	}
+
+	if addr.Index.IsREXB() {
+		rex |= 0x02
+	}
+
+	return rex
+}
+
 func encodeRexRR(reg1 Register, reg2 Register) byte {
 	var rex byte = 0x40
 
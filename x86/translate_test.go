@@ -1,6 +1,7 @@
 package x86_test
 
 import (
+	"errors"
 	"slices"
 	"testing"
 
@@ -51,6 +52,125 @@ func TestTranslate(t *testing.T) {
 			want:    []byte{0x48, 0x83, 0xc3, 0x7f},
 			wantErr: false,
 		},
+		{
+			name:    "Translate 'add eax, -1' (compressed)",
+			mnem:    x86.ADD,
+			ops:     []x86.Operand{x86.EAX, x86.Immediate(-1)},
+			want:    []byte{0x83, 0xc0, 0xff},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'add ecx, 0xffffffff' (compressed)",
+			mnem:    x86.ADD,
+			ops:     []x86.Operand{x86.ECX, x86.Immediate(0xffffffff)},
+			want:    []byte{0x83, 0xc1, 0xff},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'add rbx, 0x80'",
+			mnem:    x86.ADD,
+			ops:     []x86.Operand{x86.RBX, x86.Immediate(0x80)},
+			want:    []byte{0x48, 0x81, 0xc3, 0x80, 0x00, 0x00, 0x00},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'add rax, -0x80000000'",
+			mnem:    x86.ADD,
+			ops:     []x86.Operand{x86.RAX, x86.Immediate(-0x80000000)},
+			want:    []byte{0x48, 0x05, 0x00, 0x00, 0x00, 0x80},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov al, -128'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.AL, x86.Immediate(-128)},
+			want:    []byte{0xb0, 0x80},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov al, 300'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.AL, x86.Immediate(300)},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Translate 'mov dword [rbx], 5'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.Address{1, x86.NilReg, x86.RBX, 0, 32}, x86.Immediate(5)},
+			want:    []byte{0xc7, 0x03, 0x05, 0x00, 0x00, 0x00},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov byte [rbx], 5'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.Address{1, x86.NilReg, x86.RBX, 0, 8}, x86.Immediate(5)},
+			want:    []byte{0xc6, 0x03, 0x05},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov word [rbx], 5'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.Address{1, x86.NilReg, x86.RBX, 0, 16}, x86.Immediate(5)},
+			want:    []byte{0x66, 0xc7, 0x03, 0x05, 0x00},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov qword [rax+8], -1'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.Address{1, x86.NilReg, x86.RAX, 8, 64}, x86.Immediate(-1)},
+			want:    []byte{0x48, 0xc7, 0x40, 0x08, 0xff, 0xff, 0xff, 0xff},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'add dword [rbx], 1' (compressed)",
+			mnem:    x86.ADD,
+			ops:     []x86.Operand{x86.Address{1, x86.NilReg, x86.RBX, 0, 32}, x86.Immediate(1)},
+			want:    []byte{0x83, 0x03, 0x01},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'add qword [r8], 0x1000'",
+			mnem:    x86.ADD,
+			ops:     []x86.Operand{x86.Address{1, x86.NilReg, x86.R8, 0, 64}, x86.Immediate(0x1000)},
+			want:    []byte{0x49, 0x81, 0x00, 0x00, 0x10, 0x00, 0x00},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'add byte [rbx], 1'",
+			mnem:    x86.ADD,
+			ops:     []x86.Operand{x86.Address{1, x86.NilReg, x86.RBX, 0, 8}, x86.Immediate(1)},
+			want:    []byte{0x80, 0x03, 0x01},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'add word [r12+rcx*2], -200'",
+			mnem:    x86.ADD,
+			ops:     []x86.Operand{x86.Address{2, x86.RCX, x86.R12, 0, 16}, x86.Immediate(-200)},
+			want:    []byte{0x66, 0x41, 0x81, 0x04, 0x4c, 0x38, 0xff},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'lea eax, qword [rbx]'",
+			mnem:    x86.LEA,
+			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.NilReg, x86.RBX, 0, 64}},
+			want:    []byte{0x8d, 0x03},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov [rbx], 5' (no size)",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.Address{1, x86.NilReg, x86.RBX, 0, 0}, x86.Immediate(5)},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Translate 'mov eax, byte [rbx]' (size mismatch)",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.NilReg, x86.RBX, 0, 8}},
+			want:    nil,
+			wantErr: true,
+		},
 		{
 			name:    "Translate 'mov rax, 591'",
 			mnem:    x86.MOV,
@@ -75,35 +195,35 @@ func TestTranslate(t *testing.T) {
 		{
 			name:    "Translate 'mov eax, [rbx]'",
 			mnem:    x86.MOV,
-			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.NilReg, x86.RBX, 0}},
+			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.NilReg, x86.RBX, 0, 0}},
 			want:    []byte{0x8b, 0x03},
 			wantErr: false,
 		},
 		{
 			name:    "Translate 'mov eax, [rbx+rax]'",
 			mnem:    x86.MOV,
-			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.RAX, x86.RBX, 0}},
+			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.RAX, x86.RBX, 0, 0}},
 			want:    []byte{0x8b, 0x04, 0x03},
 			wantErr: false,
 		},
 		{
 			name:    "Translate 'mov eax, [rbx+0x7fffffff]'",
 			mnem:    x86.MOV,
-			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.NilReg, x86.RBX, 0x7fffffff}},
+			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.NilReg, x86.RBX, 0x7fffffff, 0}},
 			want:    []byte{0x8b, 0x83, 0xff, 0xff, 0xff, 0x7f},
 			wantErr: false,
 		},
 		{
 			name:    "Translate 'mov eax, [rbx+rax+0xff]'",
 			mnem:    x86.MOV,
-			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.RAX, x86.RBX, 0xff}},
+			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.RAX, x86.RBX, 0xff, 0}},
 			want:    []byte{0x8b, 0x84, 0x03, 0xff, 0x00, 0x00, 0x00},
 			wantErr: false,
 		},
 		{
 			name:    "Translate 'mov eax, [rbx+2*rax+0xff]'",
 			mnem:    x86.MOV,
-			ops:     []x86.Operand{x86.EAX, x86.Address{2, x86.RAX, x86.RBX, 0xff}},
+			ops:     []x86.Operand{x86.EAX, x86.Address{2, x86.RAX, x86.RBX, 0xff, 0}},
 			want:    []byte{0x8b, 0x84, 0x43, 0xff, 0x00, 0x00, 0x00},
 			wantErr: false,
 		},
@@ -114,6 +234,174 @@ func TestTranslate(t *testing.T) {
 			want:    []byte{0x8b, 0x04, 0x25, 0x00, 0x00, 0x00, 0x00},
 			wantErr: false,
 		},
+		{
+			name:    "Translate 'mov rcx, [rsp]'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.RCX, x86.Address{1, x86.NilReg, x86.RSP, 0, 0}},
+			want:    []byte{0x48, 0x8b, 0x0c, 0x24},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov ecx, [rbp]'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.ECX, x86.Address{1, x86.NilReg, x86.RBP, 0, 0}},
+			want:    []byte{0x8b, 0x4d, 0x00},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov rax, [rbp-8]'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.RAX, x86.Address{1, x86.NilReg, x86.RBP, -8, 0}},
+			want:    []byte{0x48, 0x8b, 0x45, 0xf8},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov r9d, [r12+r13*8+0x10]'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.R9D, x86.Address{8, x86.R13, x86.R12, 0x10, 0}},
+			want:    []byte{0x47, 0x8b, 0x4c, 0xec, 0x10},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov eax, [rcx*4+0x10]'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.EAX, x86.Address{4, x86.RCX, x86.NilReg, 0x10, 0}},
+			want:    []byte{0x8b, 0x04, 0x8d, 0x10, 0x00, 0x00, 0x00},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov al, [ebx]'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.AL, x86.Address{1, x86.NilReg, x86.EBX, 0, 0}},
+			want:    []byte{0x67, 0x8a, 0x03},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'mov [rbx+rcx*4+0x10], si'",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.Address{4, x86.RCX, x86.RBX, 0x10, 0}, x86.SI},
+			want:    []byte{0x66, 0x89, 0x74, 0x8b, 0x10},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'add [r13], r8'",
+			mnem:    x86.ADD,
+			ops:     []x86.Operand{x86.Address{1, x86.NilReg, x86.R13, 0, 0}, x86.R8},
+			want:    []byte{0x4d, 0x01, 0x45, 0x00},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'add dl, [rsi]'",
+			mnem:    x86.ADD,
+			ops:     []x86.Operand{x86.DL, x86.Address{1, x86.NilReg, x86.RSI, 0, 0}},
+			want:    []byte{0x02, 0x16},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'lea rsi, [rip+0x10]'",
+			mnem:    x86.LEA,
+			ops:     []x86.Operand{x86.RSI, x86.Address{1, x86.NilReg, x86.RIP, 0x10, 0}},
+			want:    []byte{0x48, 0x8d, 0x35, 0x10, 0x00, 0x00, 0x00},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'lea r10d, [rax+rcx]'",
+			mnem:    x86.LEA,
+			ops:     []x86.Operand{x86.R10D, x86.Address{1, x86.RCX, x86.RAX, 0, 0}},
+			want:    []byte{0x44, 0x8d, 0x14, 0x08},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'lea al, [rax]' should error",
+			mnem:    x86.LEA,
+			ops:     []x86.Operand{x86.AL, x86.Address{1, x86.NilReg, x86.RAX, 0, 0}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Translate 'mov rax, rip' should error",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.RAX, x86.RIP},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Translate 'jmp $'",
+			mnem:    x86.JMP,
+			ops:     []x86.Operand{x86.Relative{Offset: 0}},
+			want:    []byte{0xeb, 0xfe},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'jmp $+0x81'",
+			mnem:    x86.JMP,
+			ops:     []x86.Operand{x86.Relative{Offset: 0x81}},
+			want:    []byte{0xeb, 0x7f},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'jmp $+0x82'",
+			mnem:    x86.JMP,
+			ops:     []x86.Operand{x86.Relative{Offset: 0x82}},
+			want:    []byte{0xe9, 0x7d, 0x00, 0x00, 0x00},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'jmp near $'",
+			mnem:    x86.JMP,
+			ops:     []x86.Operand{x86.Relative{Offset: 0, Near: true}},
+			want:    []byte{0xe9, 0xfb, 0xff, 0xff, 0xff},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'jnz $-0x7e'",
+			mnem:    x86.JNZ,
+			ops:     []x86.Operand{x86.Relative{Offset: -0x7e}},
+			want:    []byte{0x75, 0x80},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'jg $-0x7f'",
+			mnem:    x86.JG,
+			ops:     []x86.Operand{x86.Relative{Offset: -0x7f}},
+			want:    []byte{0x0f, 0x8f, 0x7b, 0xff, 0xff, 0xff},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'call $+0x10'",
+			mnem:    x86.CALL,
+			ops:     []x86.Operand{x86.Relative{Offset: 0x10}},
+			want:    []byte{0xe8, 0x0b, 0x00, 0x00, 0x00},
+			wantErr: false,
+		},
+		{
+			name:    "Translate 'jo $+0x100000000' should error",
+			mnem:    x86.JO,
+			ops:     []x86.Operand{x86.Relative{Offset: 0x100000000}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Translate 'mov eax, [rbx+rsp]' should error",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.RSP, x86.RBX, 0, 0}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Translate 'mov eax, [rbx+ecx]' should error",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.EAX, x86.Address{1, x86.ECX, x86.RBX, 0, 0}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Translate 'mov ah, [r8]' should error",
+			mnem:    x86.MOV,
+			ops:     []x86.Operand{x86.AH, x86.Address{1, x86.NilReg, x86.R8, 0, 0}},
+			want:    nil,
+			wantErr: true,
+		},
 		{
 			name:    "Translate 'mov r15b, ah' should error",
 			mnem:    x86.MOV,
@@ -164,3 +452,130 @@ func TestTranslate(t *testing.T) {
 		})
 	}
 }
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name         string
+		mnem         x86.Mnemonic
+		ops          []x86.Operand
+		wantDispOff  int
+		wantDispSize int
+		wantImmOff   int
+		wantImmSize  int
+	}{
+		{
+			name:         "Encode 'add ebx, 0x100'",
+			mnem:         x86.ADD,
+			ops:          []x86.Operand{x86.EBX, x86.Immediate(0x100)},
+			wantDispOff:  2,
+			wantDispSize: 0,
+			wantImmOff:   2,
+			wantImmSize:  4,
+		},
+		{
+			name:         "Encode 'mov r8, 0x100'",
+			mnem:         x86.MOV,
+			ops:          []x86.Operand{x86.R8, x86.Immediate(0x100)},
+			wantDispOff:  2,
+			wantDispSize: 0,
+			wantImmOff:   2,
+			wantImmSize:  8,
+		},
+		{
+			name:         "Encode 'mov [rbx+rcx+0x80], eax'",
+			mnem:         x86.MOV,
+			ops:          []x86.Operand{x86.Address{1, x86.RCX, x86.RBX, 0x80, 0}, x86.EAX},
+			wantDispOff:  3,
+			wantDispSize: 4,
+			wantImmOff:   7,
+			wantImmSize:  0,
+		},
+		{
+			name:         "Encode 'mov dword [rbx+0x80], 0x100'",
+			mnem:         x86.MOV,
+			ops:          []x86.Operand{x86.Address{1, x86.NilReg, x86.RBX, 0x80, 32}, x86.Immediate(0x100)},
+			wantDispOff:  2,
+			wantDispSize: 4,
+			wantImmOff:   6,
+			wantImmSize:  4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := x86.Encode(tt.mnem, tt.ops...)
+			if err != nil {
+				t.Fatalf("Encode() failed: %v", err)
+			}
+
+			if got.DispOffset() != tt.wantDispOff || got.DispSize != tt.wantDispSize {
+				t.Errorf(
+					"Encode() displacement = %d:%d, want %d:%d",
+					got.DispOffset(), got.DispSize, tt.wantDispOff, tt.wantDispSize,
+				)
+			}
+
+			if got.ImmOffset() != tt.wantImmOff || got.ImmSize != tt.wantImmSize {
+				t.Errorf(
+					"Encode() immediate = %d:%d, want %d:%d",
+					got.ImmOffset(), got.ImmSize, tt.wantImmOff, tt.wantImmSize,
+				)
+			}
+		})
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		mnem x86.Mnemonic
+		ops  []x86.Operand
+		want error
+	}{
+		{
+			name: "Unsupported operands should give ErrOperandMismatch",
+			mnem: x86.LEA,
+			ops:  []x86.Operand{x86.EAX, x86.Immediate(5)},
+			want: x86.ErrOperandMismatch,
+		},
+		{
+			name: "Immediate too large for a byte register should give ErrOutOfRange",
+			mnem: x86.MOV,
+			ops:  []x86.Operand{x86.AL, x86.Immediate(300)},
+			want: x86.ErrOutOfRange,
+		},
+		{
+			name: "Immediate too large for a sign-extended imm32 should give ErrOutOfRange",
+			mnem: x86.ADD,
+			ops:  []x86.Operand{x86.RAX, x86.Immediate(0x80000000)},
+			want: x86.ErrOutOfRange,
+		},
+		{
+			name: "Immediate too small for a 16-bit register should give ErrOutOfRange",
+			mnem: x86.ADD,
+			ops:  []x86.Operand{x86.CX, x86.Immediate(-0x8001)},
+			want: x86.ErrOutOfRange,
+		},
+		{
+			name: "Mismatched memory operand size should give ErrOperandMismatch",
+			mnem: x86.ADD,
+			ops:  []x86.Operand{x86.Address{1, x86.NilReg, x86.RBX, 0, 16}, x86.ECX},
+			want: x86.ErrOperandMismatch,
+		},
+		{
+			name: "Unreachable branch target should give ErrOutOfRange",
+			mnem: x86.JMP,
+			ops:  []x86.Operand{x86.Relative{Offset: 0x100000000, Near: true}},
+			want: x86.ErrOutOfRange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := x86.Encode(tt.mnem, tt.ops...)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Encode() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}